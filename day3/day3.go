@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
@@ -20,14 +22,66 @@ var (
 	demo        = flag.Bool("demo", false, "Use demo input")
 	enablePprof = flag.Bool("pprof", false, "Enable pprof")
 
-	partOneInstructionRegex = regexp.MustCompile(`(mul|add|sub|div)\(\d+,\d+\)`)
+	operationNameRegex = regexp.MustCompile(`^[a-z_]+$`)
 
-	partTwoInstructionRegex = regexp.MustCompile(`do\(\)|don\'t\(\)|(mul|add|sub|div)\(\d+,\d+\)`)
+	defaultOperations = mustNewOperationTable(
+		Operation{name: "mul", arity: 2, eval: func(args []int) (int, error) { return args[0] * args[1], nil }},
+		Operation{name: "add", arity: 2, eval: func(args []int) (int, error) { return args[0] + args[1], nil }},
+		Operation{name: "sub", arity: 2, eval: func(args []int) (int, error) { return args[0] - args[1], nil }},
+		Operation{name: "div", arity: 2, eval: func(args []int) (int, error) {
+			if args[1] == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return args[0] / args[1], nil
+		}},
+	)
+
+	partOneInstructionRegex = defaultOperations.regex(false)
+
+	partTwoInstructionRegex = defaultOperations.regex(true)
+)
+
+const (
+	doInstruction   = "do()"
+	dontInstruction = "don't()"
+
+	// variadic marks an operation that accepts one or more arguments.
+	variadic = -1
+
+	scanChunkSize = 64 * 1024
+
+	// maxArgDigits is the most digits an argument can have, which keeps every
+	// argument within an int. Together with maxVariadicArgs it bounds the
+	// length of an instruction, and so how much the scanner carries over
+	// between chunks.
+	maxArgDigits    = 18
+	maxVariadicArgs = 32
 )
 
+var errNoInstructions = errors.New("no instructions found")
+
 type Instruction struct {
 	operation string
-	a, b      int
+	args      []int
+}
+
+// Operation describes an instruction that can appear in corrupted memory.
+type Operation struct {
+	name  string
+	arity int
+	eval  func(args []int) (int, error)
+}
+
+// operationTable drives both instruction parsing and evaluation.
+type operationTable struct {
+	ops   map[string]Operation
+	names []string
+
+	// startsInstruction marks every byte that can begin an instruction.
+	startsInstruction [256]bool
+	// maxInstructionLen is the length of the longest instruction that can
+	// match.
+	maxInstructionLen int
 }
 
 func main() {
@@ -50,95 +104,348 @@ func main() {
 		path = demoInputFile
 	}
 
-	input, err := readInputFile(path)
+	val, err := computeFile(path, partOneInstructionRegex)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	instructions, err := parseInput(input, partOneInstructionRegex)
+	fmt.Printf("Part one sum: %d\n", val)
+
+	val, err = computeFile(path, partTwoInstructionRegex)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	val, err := compute(instructions)
+	fmt.Printf("Part two sum: %d\n", val)
+}
+
+func computeFile(file string, regex *regexp.Regexp) (int, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
+	defer f.Close()
+	return computeReader(f, regex)
+}
 
-	fmt.Printf("Part one sum: %d\n", val)
+func newOperationTable(ops ...Operation) (*operationTable, error) {
+	t := &operationTable{ops: make(map[string]Operation), maxInstructionLen: len(dontInstruction)}
+	t.startsInstruction[doInstruction[0]] = true
+	t.startsInstruction[dontInstruction[0]] = true
+	for _, op := range ops {
+		if err := t.register(op); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
 
-	instructions, err = parseInput(input, partTwoInstructionRegex)
+func mustNewOperationTable(ops ...Operation) *operationTable {
+	t, err := newOperationTable(ops...)
 	if err != nil {
-		log.Fatal(err)
+		panic(err)
 	}
+	return t
+}
 
-	val, err = compute(instructions)
-	if err != nil {
-		log.Fatal(err)
+func (t *operationTable) register(op Operation) error {
+	if op.name == "" || !operationNameRegex.MatchString(op.name) {
+		return fmt.Errorf("invalid operation name: %q", op.name)
+	}
+	if op.name == "do" || op.name == "don't" {
+		return fmt.Errorf("operation name %q is reserved", op.name)
+	}
+	if _, ok := t.ops[op.name]; ok {
+		return fmt.Errorf("operation %q already registered", op.name)
+	}
+	if op.arity < variadic {
+		return fmt.Errorf("invalid arity %d for operation %q", op.arity, op.name)
 	}
+	if op.eval == nil {
+		return fmt.Errorf("operation %q has no eval function", op.name)
+	}
+	t.ops[op.name] = op
+	t.names = append(t.names, op.name)
+	t.startsInstruction[op.name[0]] = true
+	t.maxInstructionLen = max(t.maxInstructionLen, len(op.name)+argsLen(op.arity))
+	return nil
+}
 
-	fmt.Printf("Part two sum: %d\n", val)
+// regex builds the instruction regex for every registered operation,
+// optionally also matching the do() and don't() conditionals.
+func (t *operationTable) regex(conditionals bool) *regexp.Regexp {
+	var patterns []string
+	if conditionals {
+		patterns = append(patterns, regexp.QuoteMeta(doInstruction), regexp.QuoteMeta(dontInstruction))
+	}
+	for _, name := range t.names {
+		patterns = append(patterns, regexp.QuoteMeta(name)+argsPattern(t.ops[name].arity))
+	}
+	return regexp.MustCompile(strings.Join(patterns, "|"))
 }
 
-func readInputFile(file string) (string, error) {
-	bytes, err := os.ReadFile(file)
-	if err != nil {
-		return "", err
+func argsPattern(arity int) string {
+	arg := fmt.Sprintf(`\d{1,%d}`, maxArgDigits)
+	switch arity {
+	case variadic:
+		return fmt.Sprintf(`\(%s(?:,%s){0,%d}\)`, arg, arg, maxVariadicArgs-1)
+	case 0:
+		return `\(\)`
+	default:
+		return `\(` + arg + strings.Repeat(`,`+arg, arity-1) + `\)`
+	}
+}
+
+// argsLen returns the length of the longest argument list argsPattern
+// matches, parentheses included.
+func argsLen(arity int) int {
+	if arity == variadic {
+		arity = maxVariadicArgs
+	}
+	if arity == 0 {
+		return len("()")
 	}
-	return string(bytes), nil
+	return len("()") + arity*maxArgDigits + arity - 1
 }
 
 func parseInput(input string, regex *regexp.Regexp) ([]Instruction, error) {
+	return defaultOperations.parse(input, regex)
+}
+
+func (t *operationTable) parse(input string, regex *regexp.Regexp) ([]Instruction, error) {
 	var instructions []Instruction
-	matches := regex.FindAllString(input, -1)
-	if matches == nil {
-		return nil, fmt.Errorf("no instructions found")
-	}
-	do := true
-	for _, match := range matches {
-		if match == "do()" {
-			do = true
+	scanner := t.newScanner(strings.NewReader(input), regex)
+	for scanner.Scan() {
+		instructions = append(instructions, scanner.Instruction())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return instructions, nil
+}
+
+func computeReader(r io.Reader, regex *regexp.Regexp) (int, error) {
+	return defaultOperations.computeReader(r, regex)
+}
+
+// computeReader sums the enabled instructions in r without holding more than
+// a chunk of the input in memory.
+func (t *operationTable) computeReader(r io.Reader, regex *regexp.Regexp) (int, error) {
+	ret := 0
+	scanner := t.newScanner(r, regex)
+	for scanner.Scan() {
+		val, err := t.eval(scanner.Instruction())
+		if err != nil {
+			return 0, err
+		}
+		ret += val
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return ret, nil
+}
+
+// instructionScanner reads enabled instructions from a stream in fixed size
+// chunks. Any trailing bytes of a chunk that could still become an
+// instruction are carried over and rescanned with the next chunk. They are
+// always shorter than the longest instruction, so the buffer never grows.
+type instructionScanner struct {
+	r         io.Reader
+	ops       *operationTable
+	regex     *regexp.Regexp
+	chunkSize int
+
+	buf     []byte
+	pending []Instruction
+	next    int
+	curr    Instruction
+	do      bool
+	found   bool
+	eof     bool
+	err     error
+}
+
+func (t *operationTable) newScanner(r io.Reader, regex *regexp.Regexp) *instructionScanner {
+	return &instructionScanner{
+		r:         r,
+		ops:       t,
+		regex:     regex,
+		chunkSize: scanChunkSize,
+		do:        true,
+	}
+}
+
+// Scan advances to the next enabled instruction, returning false at the end
+// of the input or on error.
+func (s *instructionScanner) Scan() bool {
+	for {
+		if s.next < len(s.pending) {
+			s.curr = s.pending[s.next]
+			s.next++
+			return true
+		}
+		if s.err != nil || s.eof {
+			if s.err == nil && !s.found {
+				s.err = errNoInstructions
+			}
+			return false
+		}
+		s.pending = s.pending[:0]
+		s.next = 0
+		s.fill()
+	}
+}
+
+func (s *instructionScanner) Instruction() Instruction {
+	return s.curr
+}
+
+func (s *instructionScanner) Err() error {
+	return s.err
+}
+
+func (s *instructionScanner) fill() {
+	if s.buf == nil {
+		s.buf = make([]byte, 0, s.chunkSize+s.ops.maxInstructionLen)
+	}
+	n, err := io.ReadFull(s.r, s.buf[len(s.buf):len(s.buf)+s.chunkSize])
+	s.buf = s.buf[:len(s.buf)+n]
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		s.eof = true
+	} else if err != nil {
+		s.err = err
+		return
+	}
+
+	lastEnd := 0
+	for _, loc := range s.regex.FindAllIndex(s.buf, -1) {
+		lastEnd = loc[1]
+		s.found = true
+		match := string(s.buf[loc[0]:loc[1]])
+		if match == doInstruction {
+			s.do = true
 			continue
 		}
-		if match == "don't()" {
-			do = false
+		if match == dontInstruction {
+			s.do = false
 			continue
 		}
-		if !do {
+		if !s.do {
 			continue
 		}
-		op := match[0:3]
-		args := strings.Split(match[4:len(match)-1], ",")
-		a, err := strconv.Atoi(args[0])
+		instruction, err := s.ops.parseInstruction(match)
 		if err != nil {
-			return nil, err
+			s.err = err
+			return
 		}
-		b, err := strconv.Atoi(args[1])
-		if err != nil {
-			return nil, err
+		s.pending = append(s.pending, instruction)
+	}
+
+	if s.eof {
+		s.buf = s.buf[:0]
+		return
+	}
+	start := s.carryStart(lastEnd)
+	s.buf = s.buf[:copy(s.buf, s.buf[start:])]
+}
+
+// carryStart returns the earliest offset after lastEnd from which the rest of
+// the buffer is the beginning of an unfinished instruction. Anything that
+// starts too far back to finish within the longest instruction is dropped.
+func (s *instructionScanner) carryStart(lastEnd int) int {
+	for i := max(lastEnd, len(s.buf)-s.ops.maxInstructionLen+1); i < len(s.buf); i++ {
+		if s.ops.startsInstruction[s.buf[i]] && s.ops.isInstructionPrefix(s.buf[i:]) {
+			return i
 		}
-		instructions = append(instructions, Instruction{
-			operation: op,
-			a:         a,
-			b:         b,
-		})
 	}
-	return instructions, nil
+	return len(s.buf)
+}
+
+// isInstructionPrefix reports whether b could be extended into a complete
+// instruction. It is deliberately loose about argument syntax; the regex has
+// the final say once the rest of the instruction has been read.
+func (t *operationTable) isInstructionPrefix(b []byte) bool {
+	for _, token := range []string{doInstruction, dontInstruction} {
+		if len(b) <= len(token) && string(b) == token[:len(b)] {
+			return true
+		}
+	}
+	for _, name := range t.names {
+		if len(b) <= len(name) {
+			if string(b) == name[:len(b)] {
+				return true
+			}
+			continue
+		}
+		if string(b[:len(name)]) == name && b[len(name)] == '(' && isArgBytes(b[len(name)+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isArgBytes(b []byte) bool {
+	for _, c := range b {
+		if (c < '0' || c > '9') && c != ',' {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *operationTable) parseInstruction(match string) (Instruction, error) {
+	open := strings.IndexByte(match, '(')
+	if open == -1 || !strings.HasSuffix(match, ")") {
+		return Instruction{}, fmt.Errorf("malformed instruction: %s", match)
+	}
+	op, ok := t.ops[match[:open]]
+	if !ok {
+		return Instruction{}, fmt.Errorf("unknown operation: %s", match[:open])
+	}
+	var args []int
+	if rawArgs := match[open+1 : len(match)-1]; rawArgs != "" {
+		for _, rawArg := range strings.Split(rawArgs, ",") {
+			arg, err := strconv.Atoi(rawArg)
+			if err != nil {
+				return Instruction{}, err
+			}
+			args = append(args, arg)
+		}
+	}
+	if op.arity != variadic && len(args) != op.arity {
+		return Instruction{}, fmt.Errorf("operation %s expects %d arguments, got %d", op.name, op.arity, len(args))
+	}
+	return Instruction{
+		operation: op.name,
+		args:      args,
+	}, nil
 }
 
 func compute(instructions []Instruction) (int, error) {
+	return defaultOperations.compute(instructions)
+}
+
+func (t *operationTable) compute(instructions []Instruction) (int, error) {
 	ret := 0
 	for _, instruction := range instructions {
-		switch instruction.operation {
-		case "mul":
-			ret += instruction.a * instruction.b
-		case "add":
-			ret += instruction.a + instruction.b
-		case "sub":
-			ret += instruction.a - instruction.b
-		case "div":
-			ret += instruction.a / instruction.b
+		val, err := t.eval(instruction)
+		if err != nil {
+			return 0, err
 		}
+		ret += val
 	}
 	return ret, nil
 }
+
+func (t *operationTable) eval(instruction Instruction) (int, error) {
+	op, ok := t.ops[instruction.operation]
+	if !ok {
+		return 0, fmt.Errorf("unknown operation: %s", instruction.operation)
+	}
+	val, err := op.eval(instruction.args)
+	if err != nil {
+		return 0, fmt.Errorf("%s%v: %w", op.name, instruction.args, err)
+	}
+	return val, nil
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestParseInput(t *testing.T) {
@@ -18,9 +23,9 @@ func TestParseInput(t *testing.T) {
 			input: "mul(2,3)\nmul[7,2]___mul(4,5)\n!!mul(6,7)",
 			regex: partOneInstructionRegex,
 			expected: []Instruction{
-				{"mul", 2, 3},
-				{"mul", 4, 5},
-				{"mul", 6, 7},
+				{"mul", []int{2, 3}},
+				{"mul", []int{4, 5}},
+				{"mul", []int{6, 7}},
 			},
 			wantErr: false,
 		},
@@ -29,9 +34,9 @@ func TestParseInput(t *testing.T) {
 			input: "add(2,3)\nadd[7,2]___add(4,5)\n!!add(6,7)",
 			regex: partOneInstructionRegex,
 			expected: []Instruction{
-				{"add", 2, 3},
-				{"add", 4, 5},
-				{"add", 6, 7},
+				{"add", []int{2, 3}},
+				{"add", []int{4, 5}},
+				{"add", []int{6, 7}},
 			},
 			wantErr: false,
 		},
@@ -40,8 +45,8 @@ func TestParseInput(t *testing.T) {
 			input: "do()\nmul(2,3)\ndon't()\nmul(4,5)\ndo()\nmul(6,7)",
 			regex: partTwoInstructionRegex,
 			expected: []Instruction{
-				{"mul", 2, 3},
-				{"mul", 6, 7},
+				{"mul", []int{2, 3}},
+				{"mul", []int{6, 7}},
 			},
 			wantErr: false,
 		},
@@ -85,9 +90,9 @@ func TestCompute(t *testing.T) {
 		{
 			name: "Valid mul instructions",
 			instructions: []Instruction{
-				{"mul", 2, 3},
-				{"mul", 4, 5},
-				{"mul", 6, 7},
+				{"mul", []int{2, 3}},
+				{"mul", []int{4, 5}},
+				{"mul", []int{6, 7}},
 			},
 			expected: 68,
 			wantErr:  false,
@@ -95,9 +100,9 @@ func TestCompute(t *testing.T) {
 		{
 			name: "Valid add instructions",
 			instructions: []Instruction{
-				{"add", 2, 3},
-				{"add", 4, 5},
-				{"add", 6, 7},
+				{"add", []int{2, 3}},
+				{"add", []int{4, 5}},
+				{"add", []int{6, 7}},
 			},
 			expected: 27,
 			wantErr:  false,
@@ -111,7 +116,7 @@ func TestCompute(t *testing.T) {
 		{
 			name: "Single mul instruction",
 			instructions: []Instruction{
-				{"mul", 3, 3},
+				{"mul", []int{3, 3}},
 			},
 			expected: 9,
 			wantErr:  false,
@@ -132,12 +137,298 @@ func TestCompute(t *testing.T) {
 	}
 }
 
+func TestCustomOperations(t *testing.T) {
+	ops, err := newOperationTable(
+		Operation{name: "mod", arity: 2, eval: func(args []int) (int, error) {
+			if args[1] == 0 {
+				return 0, fmt.Errorf("modulo by zero")
+			}
+			return args[0] % args[1], nil
+		}},
+		Operation{name: "pow", arity: 2, eval: func(args []int) (int, error) {
+			ret := 1
+			for range args[1] {
+				ret *= args[0]
+			}
+			return ret, nil
+		}},
+		Operation{name: "sum", arity: variadic, eval: func(args []int) (int, error) {
+			ret := 0
+			for _, arg := range args {
+				ret += arg
+			}
+			return ret, nil
+		}},
+		Operation{name: "one", arity: 0, eval: func(args []int) (int, error) { return 1, nil }},
+	)
+	if err != nil {
+		t.Fatalf("newOperationTable() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		input        string
+		conditionals bool
+		expected     []Instruction
+		sum          int
+		wantErr      bool
+	}{
+		{
+			name:  "Fixed arity operations",
+			input: "mod(7,3)xpow(2,10)]mul(2,3)",
+			expected: []Instruction{
+				{"mod", []int{7, 3}},
+				{"pow", []int{2, 10}},
+			},
+			sum: 1025,
+		},
+		{
+			name:  "Variadic operation",
+			input: "sum(1)sum(1,2,3)sum()sum(4,)",
+			expected: []Instruction{
+				{"sum", []int{1}},
+				{"sum", []int{1, 2, 3}},
+			},
+			sum: 7,
+		},
+		{
+			name:  "Nullary operation",
+			input: "one()one(1)",
+			expected: []Instruction{
+				{"one", nil},
+			},
+			sum: 1,
+		},
+		{
+			name:         "Conditionals",
+			input:        "sum(1,1)don't()pow(2,2)do()mod(5,3)",
+			conditionals: true,
+			expected: []Instruction{
+				{"sum", []int{1, 1}},
+				{"mod", []int{5, 3}},
+			},
+			sum: 4,
+		},
+		{
+			name:    "Evaluation error",
+			input:   "mod(1,0)",
+			wantErr: true,
+			expected: []Instruction{
+				{"mod", []int{1, 0}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ops.parse(tt.input, ops.regex(tt.conditionals))
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if !equalInstructions(got, tt.expected) {
+				t.Errorf("parse() = %v, expected %v", got, tt.expected)
+			}
+			sum, err := ops.compute(got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compute() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && sum != tt.sum {
+				t.Errorf("compute() = %v, expected %v", sum, tt.sum)
+			}
+		})
+	}
+}
+
+func TestRegisterOperation(t *testing.T) {
+	eval := func(args []int) (int, error) { return 0, nil }
+	tests := []struct {
+		name    string
+		op      Operation
+		wantErr bool
+	}{
+		{"Valid", Operation{name: "mod", arity: 2, eval: eval}, false},
+		{"Duplicate", Operation{name: "mul", arity: 2, eval: eval}, true},
+		{"Reserved", Operation{name: "do", arity: 0, eval: eval}, true},
+		{"Empty name", Operation{name: "", arity: 2, eval: eval}, true},
+		{"Invalid name", Operation{name: "mu(l", arity: 2, eval: eval}, true},
+		{"Invalid arity", Operation{name: "neg", arity: -2, eval: eval}, true},
+		{"Missing eval", Operation{name: "neg", arity: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := newOperationTable(Operation{name: "mul", arity: 2, eval: eval})
+			if err != nil {
+				t.Fatalf("newOperationTable() error = %v", err)
+			}
+			if err := ops.register(tt.op); (err != nil) != tt.wantErr {
+				t.Errorf("register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScannerChunkBoundaries(t *testing.T) {
+	input := "xmul(2,4)&mul[3,7]!^don't()_mul(5,5)+mul(32,64](mul(11,8)undo()?mul(8,5))" +
+		"sub(100,1)do()do()add(12345,67890)don'tmul(1,1)don't()div(9,3)do()mul(123,4)mu"
+
+	for _, regex := range []*regexp.Regexp{partOneInstructionRegex, partTwoInstructionRegex} {
+		expected, err := parseInput(input, regex)
+		if err != nil {
+			t.Fatalf("parseInput() error = %v", err)
+		}
+		want, err := compute(expected)
+		if err != nil {
+			t.Fatalf("compute() error = %v", err)
+		}
+		for chunkSize := 1; chunkSize <= len(input); chunkSize++ {
+			scanner := defaultOperations.newScanner(strings.NewReader(input), regex)
+			scanner.chunkSize = chunkSize
+			var got []Instruction
+			for scanner.Scan() {
+				got = append(got, scanner.Instruction())
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatalf("chunk size %d: Err() = %v", chunkSize, err)
+			}
+			if !equalInstructions(got, expected) {
+				t.Errorf("chunk size %d: got %v, expected %v", chunkSize, got, expected)
+			}
+		}
+		got, err := computeReader(iotest.OneByteReader(strings.NewReader(input)), regex)
+		if err != nil {
+			t.Fatalf("computeReader() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("computeReader() = %d, expected %d", got, want)
+		}
+	}
+}
+
+func TestScannerLongInstructions(t *testing.T) {
+	filler := strings.Repeat("x", 60*1024)
+	arg := func(n int) string {
+		return fmt.Sprintf("%0*d", maxArgDigits, n)
+	}
+	longest := "sum(" + strings.Repeat(arg(1)+",", maxVariadicArgs-1) + arg(1) + ")"
+	padded := "mul(" + arg(2) + "," + arg(3) + ")"
+	// Instructions too long to match must not hide what follows them, however
+	// they fall across chunks.
+	tooManyArgs := "sum(" + strings.Repeat("1,", maxVariadicArgs) + "1)"
+	tooManyDigits := "mul(0" + arg(4) + ",5)"
+	broken := "sum(" + strings.Repeat("1,", 5000) + "x"
+	input := filler + longest + filler + padded + tooManyArgs + tooManyDigits + broken + "mul(3,4)"
+	const expected = maxVariadicArgs + 6 + 12
+	ops := mustNewOperationTable(
+		Operation{name: "mul", arity: 2, eval: func(args []int) (int, error) { return args[0] * args[1], nil }},
+		Operation{name: "sum", arity: variadic, eval: func(args []int) (int, error) {
+			ret := 0
+			for _, arg := range args {
+				ret += arg
+			}
+			return ret, nil
+		}},
+	)
+	regex := ops.regex(false)
+
+	if len(longest) != ops.maxInstructionLen {
+		t.Fatalf("longest instruction is %d bytes, expected %d", len(longest), ops.maxInstructionLen)
+	}
+	for _, chunkSize := range []int{1, 7, 1000, len(longest), scanChunkSize, 1 << 20} {
+		scanner := ops.newScanner(strings.NewReader(input), regex)
+		scanner.chunkSize = chunkSize
+		total := 0
+		for scanner.Scan() {
+			val, err := ops.eval(scanner.Instruction())
+			if err != nil {
+				t.Fatalf("chunk size %d: eval() error = %v", chunkSize, err)
+			}
+			total += val
+			if cap(scanner.buf) > chunkSize+ops.maxInstructionLen {
+				t.Fatalf("chunk size %d: buffer grew to %d bytes", chunkSize, cap(scanner.buf))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("chunk size %d: Err() = %v", chunkSize, err)
+		}
+		if total != expected {
+			t.Errorf("chunk size %d: total = %d, expected %d", chunkSize, total, expected)
+		}
+	}
+	got, err := ops.computeReader(iotest.HalfReader(strings.NewReader(input)), regex)
+	if err != nil {
+		t.Fatalf("computeReader() error = %v", err)
+	}
+	if got != expected {
+		t.Errorf("computeReader() = %d, expected %d", got, expected)
+	}
+}
+
+func TestScannerErrors(t *testing.T) {
+	if _, err := computeReader(strings.NewReader("nothing to see here"), partOneInstructionRegex); err != errNoInstructions {
+		t.Errorf("computeReader() error = %v, expected %v", err, errNoInstructions)
+	}
+	readErr := fmt.Errorf("read failed")
+	if _, err := computeReader(iotest.ErrReader(readErr), partOneInstructionRegex); err != readErr {
+		t.Errorf("computeReader() error = %v, expected %v", err, readErr)
+	}
+}
+
+func TestScannerConstantMemory(t *testing.T) {
+	scanner := defaultOperations.newScanner(newMemoryDump(1<<20), partTwoInstructionRegex)
+	count := 0
+	for scanner.Scan() {
+		count++
+		if cap(scanner.buf) > scanChunkSize+defaultOperations.maxInstructionLen {
+			t.Fatalf("buffer grew to %d bytes", cap(scanner.buf))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if count == 0 {
+		t.Errorf("no instructions scanned")
+	}
+}
+
+// memoryDump generates a corrupted memory dump of a fixed size on the fly.
+type memoryDump struct {
+	pattern   []byte
+	offset    int
+	remaining int64
+}
+
+func newMemoryDump(size int64) *memoryDump {
+	return &memoryDump{
+		pattern:   []byte("xmul(2,4)&mul[3,7]!^don't()_mul(5,5)+mul(32,64](mul(11,8)undo()?mul(8,5))add(123,456)\n"),
+		remaining: size,
+	}
+}
+
+func (d *memoryDump) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], d.pattern[d.offset:])
+		n += c
+		d.offset = (d.offset + c) % len(d.pattern)
+	}
+	d.remaining -= int64(n)
+	return n, nil
+}
+
 func equalInstructions(a, b []Instruction) bool {
 	if len(a) != len(b) {
 		return false
 	}
 	for i := range a {
-		if a[i] != b[i] {
+		if a[i].operation != b[i].operation || !slices.Equal(a[i].args, b[i].args) {
 			return false
 		}
 	}
@@ -154,11 +445,22 @@ func BenchmarkParseInput(b *testing.B) {
 
 func BenchmarkCompute(b *testing.B) {
 	instructions := []Instruction{
-		{"mul", 2, 3},
-		{"mul", 4, 5},
-		{"mul", 6, 7},
+		{"mul", []int{2, 3}},
+		{"mul", []int{4, 5}},
+		{"mul", []int{6, 7}},
 	}
 	for i := 0; i < b.N; i++ {
 		compute(instructions)
 	}
 }
+
+func BenchmarkComputeReader(b *testing.B) {
+	const size = 2 << 30
+	b.SetBytes(size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := computeReader(newMemoryDump(size), partTwoInstructionRegex); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"runtime/pprof"
+	"slices"
 	"strings"
 )
 
@@ -18,6 +19,10 @@ var (
 	demo        = flag.Bool("demo", false, "Use demo input")
 	enablePprof = flag.Bool("pprof", false, "Enable pprof")
 	verbose     = flag.Bool("v", false, "Enable verbose output")
+	render      = flag.String("render", "", "Render the matches of a puzzle part (xmas or x-mas), blanking every other cell")
+	color       = flag.Bool("color", false, "Colour rendered matches by direction")
+	renderOut   = flag.String("render-out", "", "Write the rendered grid to this file instead of stdout")
+	words       = flag.String("words", "", "Comma separated words to count in every direction")
 )
 
 func main() {
@@ -54,6 +59,20 @@ func main() {
 
 	fmt.Printf("Xmas count: %d\n", xmasCount)
 	fmt.Printf("Mas count: %d\n", masCount)
+
+	if *words != "" {
+		wordList := strings.Split(*words, ",")
+		counts := countWords(matrix, wordList)
+		for _, word := range wordList {
+			fmt.Printf("%s count: %d\n", word, counts[word])
+		}
+	}
+
+	if *render != "" {
+		if err := writeRender(matrix, *render, *color, *renderOut); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 func readInputFile(file string) (string, error) {
@@ -94,77 +113,310 @@ func printMatrix(matrix [][]string) {
 	fmt.Println()
 }
 
-// ew
-func startsXmas(matrix [][]string, row, col int) int {
-	found := 0
-	// Left to right
-	if col+3 < len(matrix[row]) {
-		if matrix[row][col] == "X" && matrix[row][col+1] == "M" && matrix[row][col+2] == "A" && matrix[row][col+3] == "S" {
-			if *verbose {
-				fmt.Printf("Found left to right xmas at %d, %d\n", row+1, col+1)
-			}
-			found++
+// cell is a position in the grid.
+type cell struct {
+	row, col int
+}
+
+// ansiColors are cycled through when colouring rendered matches.
+var ansiColors = []int{31, 32, 33, 34, 35, 36, 91, 92}
+
+func writeRender(matrix [][]string, part string, color bool, out string) error {
+	var highlights map[cell]int
+	switch part {
+	case "xmas":
+		highlights = wordHighlights(searchWords(matrix, []string{"XMAS"}, allDirections))
+	case "x-mas":
+		highlights = stencilHighlights(matrix, xmasVariants)
+	default:
+		return fmt.Errorf("unknown render mode: %s", part)
+	}
+	rendered := renderMatrix(matrix, highlights, color)
+	if out == "" {
+		fmt.Print(rendered)
+		return nil
+	}
+	return os.WriteFile(out, []byte(rendered), 0644)
+}
+
+// wordHighlights maps every cell covered by a match to the index of the
+// match's direction in allDirections.
+func wordHighlights(matches []wordMatch) map[cell]int {
+	highlights := make(map[cell]int)
+	for _, match := range matches {
+		group := slices.Index(allDirections, match.dir)
+		for k := range len(strings.Split(match.word, "")) {
+			highlights[cell{match.row + k*match.dir.dRow, match.col + k*match.dir.dCol}] = group
 		}
 	}
-	// Right to left
-	if col-3 >= 0 {
-		if matrix[row][col] == "X" && matrix[row][col-1] == "M" && matrix[row][col-2] == "A" && matrix[row][col-3] == "S" {
-			if *verbose {
-				fmt.Printf("Found right to left xmas at %d, %d\n", row+1, col+1)
+	return highlights
+}
+
+// stencilHighlights maps every non-wildcard cell of a stencil placement to the
+// index of the matching variant.
+func stencilHighlights(matrix [][]string, variants []stencil) map[cell]int {
+	highlights := make(map[cell]int)
+	for i, row := range matrix {
+		for j := range row {
+			for group, v := range variants {
+				if !v.matchesAt(matrix, i, j) {
+					continue
+				}
+				for r, letters := range v {
+					for c, letter := range letters {
+						if letter != stencilWildcard {
+							highlights[cell{i + r, j + c}] = group
+						}
+					}
+				}
 			}
-			found++
 		}
 	}
-	// Top to bottom
-	if row+3 < len(matrix) {
-		if matrix[row][col] == "X" && matrix[row+1][col] == "M" && matrix[row+2][col] == "A" && matrix[row+3][col] == "S" {
-			if *verbose {
-				fmt.Printf("Found top to bottom xmas at %d, %d\n", row+1, col+1)
+	return highlights
+}
+
+// renderMatrix draws the grid the way the puzzle text does, replacing every
+// cell that is not part of a match with a ".".
+func renderMatrix(matrix [][]string, highlights map[cell]int, color bool) string {
+	var sb strings.Builder
+	for i, row := range matrix {
+		for j, letter := range row {
+			group, ok := highlights[cell{i, j}]
+			switch {
+			case !ok:
+				sb.WriteString(".")
+			case color:
+				fmt.Fprintf(&sb, "\033[%dm%s\033[0m", ansiColors[group%len(ansiColors)], letter)
+			default:
+				sb.WriteString(letter)
 			}
-			found++
 		}
+		sb.WriteString("\n")
 	}
-	// Bottom to top
-	if row-3 >= 0 {
-		if matrix[row][col] == "X" && matrix[row-1][col] == "M" && matrix[row-2][col] == "A" && matrix[row-3][col] == "S" {
-			if *verbose {
-				fmt.Printf("Found bottom to top xmas at %d, %d\n", row+1, col+1)
-			}
-			found++
+	return sb.String()
+}
+
+type direction struct {
+	dRow, dCol int
+}
+
+var (
+	orthogonalDirections = []direction{{0, 1}, {0, -1}, {1, 0}, {-1, 0}}
+	diagonalDirections   = []direction{{1, 1}, {-1, -1}, {1, -1}, {-1, 1}}
+	allDirections        = append(slices.Clone(orthogonalDirections), diagonalDirections...)
+
+	directionNames = map[direction]string{
+		{0, 1}:   "left to right",
+		{0, -1}:  "right to left",
+		{1, 0}:   "top to bottom",
+		{-1, 0}:  "bottom to top",
+		{1, 1}:   "diagonal top left to bottom right",
+		{-1, -1}: "diagonal bottom right to top left",
+		{1, -1}:  "diagonal top right to bottom left",
+		{-1, 1}:  "diagonal bottom left to top right",
+	}
+)
+
+func (d direction) String() string {
+	if name, ok := directionNames[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("(%d, %d)", d.dRow, d.dCol)
+}
+
+// wordMatch is a word found in the grid, starting at row, col and reading
+// along dir.
+type wordMatch struct {
+	word     string
+	row, col int
+	dir      direction
+}
+
+// searchWords finds every occurrence of words in the matrix reading along any
+// of the given directions.
+func searchWords(matrix [][]string, words []string, directions []direction) []wordMatch {
+	letters := splitWords(words)
+	var matches []wordMatch
+	for i, row := range matrix {
+		for j := range row {
+			matches = append(matches, searchLettersAt(matrix, i, j, words, letters, directions)...)
 		}
 	}
-	// Diagonal top left to bottom right
-	if col+3 < len(matrix[row]) && row+3 < len(matrix) {
-		if matrix[row][col] == "X" && matrix[row+1][col+1] == "M" && matrix[row+2][col+2] == "A" && matrix[row+3][col+3] == "S" {
+	return matches
+}
+
+// searchWordsAt finds the occurrences of words starting at row, col.
+func searchWordsAt(matrix [][]string, row, col int, words []string, directions []direction) []wordMatch {
+	return searchLettersAt(matrix, row, col, words, splitWords(words), directions)
+}
+
+func splitWords(words []string) [][]string {
+	letters := make([][]string, len(words))
+	for i, word := range words {
+		letters[i] = strings.Split(word, "")
+	}
+	return letters
+}
+
+func searchLettersAt(matrix [][]string, row, col int, words []string, letters [][]string, directions []direction) []wordMatch {
+	var matches []wordMatch
+	for i, word := range words {
+		if word == "" {
+			continue
+		}
+		for _, dir := range directions {
+			if !matchesWord(matrix, row, col, letters[i], dir) {
+				continue
+			}
 			if *verbose {
-				fmt.Printf("Found diagonal top left to bottom right xmas at %d, %d\n", row+1, col+1)
+				fmt.Printf("Found %s %s at %d, %d\n", dir, strings.ToLower(word), row+1, col+1)
 			}
-			found++
+			matches = append(matches, wordMatch{
+				word: word,
+				row:  row,
+				col:  col,
+				dir:  dir,
+			})
 		}
 	}
-	// Diagonal bottom right to top left
-	if col-3 >= 0 && row-3 >= 0 {
-		if matrix[row][col] == "X" && matrix[row-1][col-1] == "M" && matrix[row-2][col-2] == "A" && matrix[row-3][col-3] == "S" {
-			if *verbose {
-				fmt.Printf("Found diagonal bottom right to top left xmas at %d, %d\n", row+1, col+1)
+	return matches
+}
+
+func matchesWord(matrix [][]string, row, col int, letters []string, dir direction) bool {
+	for k, letter := range letters {
+		r := row + k*dir.dRow
+		c := col + k*dir.dCol
+		if r < 0 || r >= len(matrix) || c < 0 || c >= len(matrix[r]) || matrix[r][c] != letter {
+			return false
+		}
+	}
+	return true
+}
+
+func startsXmas(matrix [][]string, row, col int) int {
+	return len(searchWordsAt(matrix, row, col, []string{"XMAS"}, allDirections))
+}
+
+const stencilWildcard = "."
+
+// stencil is a small rectangular pattern of letters to find in the grid.
+// Wildcard cells match any letter.
+type stencil [][]string
+
+var xmasVariants = mustParseStencil("M.S/.A./M.S").variants()
+
+// parseStencil parses a template with rows separated by "/", e.g. "M.S/.A./M.S".
+func parseStencil(template string) (stencil, error) {
+	var s stencil
+	for _, row := range strings.Split(template, "/") {
+		if row == "" {
+			return nil, fmt.Errorf("invalid stencil %q: empty row", template)
+		}
+		letters := strings.Split(row, "")
+		if len(s) > 0 && len(letters) != len(s[0]) {
+			return nil, fmt.Errorf("invalid stencil %q: rows have different lengths", template)
+		}
+		s = append(s, letters)
+	}
+	return s, nil
+}
+
+func mustParseStencil(template string) stencil {
+	s, err := parseStencil(template)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s stencil) String() string {
+	rows := make([]string, len(s))
+	for i, row := range s {
+		rows[i] = strings.Join(row, "")
+	}
+	return strings.Join(rows, "/")
+}
+
+// rotate returns the stencil turned 90 degrees clockwise.
+func (s stencil) rotate() stencil {
+	rotated := make(stencil, len(s[0]))
+	for i := range rotated {
+		rotated[i] = make([]string, len(s))
+		for j := range rotated[i] {
+			rotated[i][j] = s[len(s)-1-j][i]
+		}
+	}
+	return rotated
+}
+
+// reflect returns the stencil mirrored left to right.
+func (s stencil) reflect() stencil {
+	reflected := make(stencil, len(s))
+	for i, row := range s {
+		reflected[i] = slices.Clone(row)
+		slices.Reverse(reflected[i])
+	}
+	return reflected
+}
+
+// variants returns every distinct rotation and reflection of the stencil.
+func (s stencil) variants() []stencil {
+	var variants []stencil
+	seen := make(map[string]bool)
+	for _, v := range []stencil{s, s.reflect()} {
+		for range 4 {
+			if key := v.String(); !seen[key] {
+				seen[key] = true
+				variants = append(variants, v)
 			}
-			found++
+			v = v.rotate()
 		}
 	}
-	// Diagonal top right to bottom left
-	if col-3 >= 0 && row+3 < len(matrix) {
-		if matrix[row][col] == "X" && matrix[row+1][col-1] == "M" && matrix[row+2][col-2] == "A" && matrix[row+3][col-3] == "S" {
-			if *verbose {
-				fmt.Printf("Found diagonal top right to bottom left xmas at %d, %d\n", row+1, col+1)
+	return variants
+}
+
+// matchesAt reports whether the stencil matches with its top left corner at
+// row, col.
+func (s stencil) matchesAt(matrix [][]string, row, col int) bool {
+	if row < 0 || row+len(s) > len(matrix) {
+		return false
+	}
+	for i, letters := range s {
+		if col < 0 || col+len(letters) > len(matrix[row+i]) {
+			return false
+		}
+		for j, letter := range letters {
+			if letter != stencilWildcard && matrix[row+i][col+j] != letter {
+				return false
 			}
-			found++
 		}
 	}
-	// Diagonal bottom left to top right
-	if col+3 < len(matrix[row]) && row-3 >= 0 {
-		if matrix[row][col] == "X" && matrix[row-1][col+1] == "M" && matrix[row-2][col+2] == "A" && matrix[row-3][col+3] == "S" {
+	return true
+}
+
+// countStencil counts every placement of the template, in any orientation,
+// in the grid.
+func countStencil(matrix [][]string, template string) (int, error) {
+	s, err := parseStencil(template)
+	if err != nil {
+		return 0, err
+	}
+	variants := s.variants()
+	count := 0
+	for i, row := range matrix {
+		for j := range row {
+			count += countStencilsAt(matrix, i, j, variants)
+		}
+	}
+	return count, nil
+}
+
+func countStencilsAt(matrix [][]string, row, col int, variants []stencil) int {
+	found := 0
+	for _, v := range variants {
+		if v.matchesAt(matrix, row, col) {
 			if *verbose {
-				fmt.Printf("Found diagonal bottom left to top right xmas at %d, %d\n", row+1, col+1)
+				fmt.Printf("Found %s at %d, %d\n", v, row+1, col+1)
 			}
 			found++
 		}
@@ -172,44 +424,166 @@ func startsXmas(matrix [][]string, row, col int) int {
 	return found
 }
 
-// ew
+// startsMas counts the X-MAS shapes anchored at the M at row, col. An X-MAS
+// is anchored at its top left corner when that holds an M and at its bottom
+// right corner otherwise, so every shape in the grid is counted exactly once.
 func startsMas(matrix [][]string, row, col int) int {
 	found := 0
-	// Top to bottom
-	if row+2 < len(matrix) && col+2 < len(matrix[row]) {
-		if matrix[row][col] == "M" && matrix[row+1][col+1] == "A" && matrix[row+2][col+2] == "S" && matrix[row][col+2] == "M" && matrix[row+2][col] == "S" {
+	for _, v := range xmasVariants {
+		top, left := row, col
+		if v[0][0] != "M" {
+			top, left = row-len(v)+1, col-len(v[0])+1
+		}
+		if v.matchesAt(matrix, top, left) {
 			if *verbose {
-				fmt.Printf("Found top to bottom x-mas at %d, %d\n", row+1, col+1)
+				fmt.Printf("Found %s at %d, %d\n", v, top+1, left+1)
 			}
 			found++
 		}
 	}
-	// Left to right
-	if row+2 < len(matrix) && col+2 < len(matrix[row]) {
-		if matrix[row][col] == "M" && matrix[row+1][col+1] == "A" && matrix[row+2][col+2] == "S" && matrix[row+2][col] == "M" && matrix[row][col+2] == "S" {
-			if *verbose {
-				fmt.Printf("Found left to right x-mas at %d, %d\n", row+1, col+1)
+	return found
+}
+
+// gridLines returns every row, column, diagonal and anti-diagonal of the grid,
+// each read in both directions. Lines are split wherever a row is too short
+// to reach them.
+func gridLines(matrix [][]string) []string {
+	width := 0
+	for _, row := range matrix {
+		width = max(width, len(row))
+	}
+
+	type lineStart struct {
+		cell
+		dir direction
+	}
+	var starts []lineStart
+	addStart := func(row, col int, dir direction) {
+		starts = append(starts, lineStart{cell{row, col}, dir})
+	}
+	for i := range matrix {
+		addStart(i, 0, direction{0, 1})
+	}
+	for j := range width {
+		addStart(0, j, direction{1, 0})
+		addStart(0, j, direction{1, 1})
+		addStart(0, j, direction{1, -1})
+	}
+	for i := 1; i < len(matrix); i++ {
+		addStart(i, 0, direction{1, 1})
+		addStart(i, width-1, direction{1, -1})
+	}
+
+	var lines []string
+	for _, start := range starts {
+		var segment []string
+		flush := func() {
+			if len(segment) == 0 {
+				return
 			}
-			found++
+			lines = append(lines, strings.Join(segment, ""))
+			slices.Reverse(segment)
+			lines = append(lines, strings.Join(segment, ""))
+			segment = segment[:0]
+		}
+		for r, c := start.row, start.col; r < len(matrix) && c >= 0 && c < width; r, c = r+start.dir.dRow, c+start.dir.dCol {
+			if c >= len(matrix[r]) {
+				flush()
+				continue
+			}
+			segment = append(segment, matrix[r][c])
 		}
+		flush()
 	}
-	// Bottom to top
-	if row-2 >= 0 && col-2 >= 0 {
-		if matrix[row][col] == "M" && matrix[row-1][col-1] == "A" && matrix[row-2][col-2] == "S" && matrix[row][col-2] == "M" && matrix[row-2][col] == "S" {
-			if *verbose {
-				fmt.Printf("Found bottom to top x-mas at %d, %d\n", row+1, col+1)
+	return lines
+}
+
+// countWords counts the occurrences of each word in every direction by running
+// a single Aho-Corasick automaton over all of the grid's lines.
+func countWords(matrix [][]string, words []string) map[string]int {
+	automaton := newAhoCorasick(words)
+	counts := make([]int, len(words))
+	for _, line := range gridLines(matrix) {
+		automaton.count(line, counts)
+	}
+	ret := make(map[string]int, len(words))
+	for i, word := range words {
+		ret[word] = counts[i]
+	}
+	return ret
+}
+
+// ahoCorasick is a multi-pattern matching automaton with a fully expanded
+// byte transition table.
+type ahoCorasick struct {
+	next   [][256]int32
+	output [][]int
+}
+
+func newAhoCorasick(words []string) *ahoCorasick {
+	a := &ahoCorasick{}
+	a.addNode()
+	for i, word := range words {
+		if word == "" {
+			continue
+		}
+		node := 0
+		for k := range len(word) {
+			child := a.next[node][word[k]]
+			if child == -1 {
+				child = a.addNode()
+				a.next[node][word[k]] = child
 			}
-			found++
+			node = int(child)
 		}
+		a.output[node] = append(a.output[node], i)
 	}
-	// Right to left
-	if row-2 >= 0 && col-2 >= 0 {
-		if matrix[row][col] == "M" && matrix[row-1][col-1] == "A" && matrix[row-2][col-2] == "S" && matrix[row-2][col] == "M" && matrix[row][col-2] == "S" {
-			if *verbose {
-				fmt.Printf("Found right to left x-mas at %d, %d\n", row+1, col+1)
+
+	// Breadth first, point missing transitions at the longest proper suffix
+	// state and inherit that state's output.
+	fail := make([]int32, len(a.next))
+	var queue []int32
+	for b := range 256 {
+		if child := a.next[0][b]; child == -1 {
+			a.next[0][b] = 0
+		} else {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		a.output[node] = append(a.output[node], a.output[fail[node]]...)
+		for b := range 256 {
+			child := a.next[node][b]
+			if child == -1 {
+				a.next[node][b] = a.next[fail[node]][b]
+				continue
 			}
-			found++
+			fail[child] = a.next[fail[node]][b]
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+func (a *ahoCorasick) addNode() int32 {
+	var transitions [256]int32
+	for b := range transitions {
+		transitions[b] = -1
+	}
+	a.next = append(a.next, transitions)
+	a.output = append(a.output, nil)
+	return int32(len(a.next) - 1)
+}
+
+// count adds the number of occurrences in text of each word to counts.
+func (a *ahoCorasick) count(text string, counts []int) {
+	node := int32(0)
+	for k := range len(text) {
+		node = a.next[node][text[k]]
+		for _, word := range a.output[node] {
+			counts[word]++
 		}
 	}
-	return found
 }
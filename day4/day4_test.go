@@ -1,6 +1,9 @@
 package main
 
 import (
+	"math/rand"
+	"reflect"
+	"slices"
 	"testing"
 )
 
@@ -53,6 +56,74 @@ func TestStartsXmas(t *testing.T) {
 	}
 }
 
+func TestSearchWords(t *testing.T) {
+	matrix := [][]string{
+		{"C", "A", "T", "S"},
+		{"A", "T", "O", "X"},
+		{"T", "A", "C", "O"},
+		{"D", "O", "G", "T"},
+	}
+	tests := []struct {
+		name       string
+		words      []string
+		directions []direction
+		expected   []wordMatch
+	}{
+		{
+			name:       "Orthogonal",
+			words:      []string{"CAT", "DOG"},
+			directions: orthogonalDirections,
+			expected: []wordMatch{
+				{word: "CAT", row: 0, col: 0, dir: direction{0, 1}},
+				{word: "CAT", row: 0, col: 0, dir: direction{1, 0}},
+				{word: "CAT", row: 2, col: 2, dir: direction{0, -1}},
+				{word: "DOG", row: 3, col: 0, dir: direction{0, 1}},
+			},
+		},
+		{
+			name:       "Diagonal",
+			words:      []string{"CAT", "TO"},
+			directions: diagonalDirections,
+			expected: []wordMatch{
+				{word: "TO", row: 2, col: 0, dir: direction{1, 1}},
+			},
+		},
+		{
+			name:       "All",
+			words:      []string{"TAC"},
+			directions: allDirections,
+			expected: []wordMatch{
+				{word: "TAC", row: 0, col: 2, dir: direction{0, -1}},
+				{word: "TAC", row: 2, col: 0, dir: direction{0, 1}},
+				{word: "TAC", row: 2, col: 0, dir: direction{-1, 0}},
+			},
+		},
+		{
+			name:       "Custom knight move",
+			words:      []string{"CO"},
+			directions: []direction{{1, 2}},
+			expected: []wordMatch{
+				{word: "CO", row: 0, col: 0, dir: direction{1, 2}},
+			},
+		},
+		{
+			name:       "No words",
+			words:      []string{""},
+			directions: allDirections,
+			expected:   nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := searchWords(matrix, test.words, test.directions)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("searchWords(%v, %v) = %v; expected %v", test.words, test.directions, result, test.expected)
+			}
+		})
+	}
+}
+
 func TestStartsXMas(t *testing.T) {
 	tests := []struct {
 		matrix   [][]string
@@ -101,3 +172,210 @@ func TestStartsXMas(t *testing.T) {
 		}
 	}
 }
+
+func TestStencilVariants(t *testing.T) {
+	tests := []struct {
+		template string
+		expected []string
+	}{
+		{"M.S/.A./M.S", []string{"M.S/.A./M.S", "M.M/.A./S.S", "S.M/.A./S.M", "S.S/.A./M.M"}},
+		{"AB", []string{"AB", "A/B", "BA", "B/A"}},
+		{"A", []string{"A"}},
+		{"A./BC", []string{"A./BC", "BA/C.", "CB/.A", ".C/AB", ".A/CB", "C./BA", "BC/A.", "AB/.C"}},
+	}
+
+	for _, test := range tests {
+		s, err := parseStencil(test.template)
+		if err != nil {
+			t.Fatalf("parseStencil(%q) returned error: %v", test.template, err)
+		}
+		var result []string
+		for _, v := range s.variants() {
+			result = append(result, v.String())
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("parseStencil(%q).variants() = %v; expected %v", test.template, result, test.expected)
+		}
+	}
+}
+
+func TestParseStencilErrors(t *testing.T) {
+	for _, template := range []string{"", "AB/C", "A//B", "AB/"} {
+		if _, err := parseStencil(template); err == nil {
+			t.Errorf("parseStencil(%q) expected error", template)
+		}
+	}
+}
+
+func TestCountStencil(t *testing.T) {
+	matrix, err := parseInput(demoGrid)
+	if err != nil {
+		t.Fatalf("parseInput() returned error: %v", err)
+	}
+	tests := []struct {
+		template string
+		expected int
+	}{
+		{"M.S/.A./M.S", 9},
+		{"XMAS", 8},
+		{"X", 19},
+	}
+
+	for _, test := range tests {
+		result, err := countStencil(matrix, test.template)
+		if err != nil {
+			t.Errorf("countStencil(%q) returned error: %v", test.template, err)
+		}
+		if result != test.expected {
+			t.Errorf("countStencil(%q) = %d; expected %d", test.template, result, test.expected)
+		}
+	}
+}
+
+func TestRenderMatrix(t *testing.T) {
+	matrix := [][]string{
+		{"M", "M", "M", "S", "X", "X", "M", "A", "S", "M"},
+		{"M", "S", "A", "M", "X", "M", "S", "M", "S", "A"},
+		{"A", "M", "X", "S", "X", "M", "A", "A", "M", "M"},
+	}
+	tests := []struct {
+		name       string
+		highlights map[cell]int
+		color      bool
+		expected   string
+	}{
+		{
+			name:       "xmas",
+			highlights: wordHighlights(searchWords(matrix, []string{"XMAS"}, allDirections)),
+			expected:   ".....XMAS.\n.SAMX.....\n..........\n",
+		},
+		{
+			name:       "x-mas",
+			highlights: stencilHighlights(matrix, xmasVariants),
+			expected:   ".M.S......\n..A.......\n.M.S......\n",
+		},
+		{
+			name:       "colour",
+			highlights: map[cell]int{{0, 0}: 0, {2, 9}: 9},
+			color:      true,
+			expected:   "\033[31mM\033[0m.........\n..........\n.........\033[32mM\033[0m\n",
+		},
+	}
+
+	for _, test := range tests {
+		result := renderMatrix(matrix, test.highlights, test.color)
+		if result != test.expected {
+			t.Errorf("renderMatrix(%s) = %q; expected %q", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestGridLines(t *testing.T) {
+	matrix := [][]string{
+		{"A", "B", "C"},
+		{"D", "E", "F"},
+		{"G", "H"},
+	}
+	expected := []string{
+		"ABC", "CBA", "DEF", "FED", "GH", "HG",
+		"ADG", "GDA", "BEH", "HEB", "CF", "FC",
+		"AE", "EA", "BF", "FB", "C", "C", "DH", "HD", "G", "G",
+		"A", "A", "BD", "DB", "CEG", "GEC", "FH", "HF",
+	}
+	result := gridLines(matrix)
+	slices.Sort(result)
+	slices.Sort(expected)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("gridLines() = %v; expected %v", result, expected)
+	}
+}
+
+func TestCountWords(t *testing.T) {
+	demo, err := parseInput(demoGrid)
+	if err != nil {
+		t.Fatalf("parseInput() returned error: %v", err)
+	}
+	tests := []struct {
+		name   string
+		matrix [][]string
+		words  []string
+	}{
+		{"Demo", demo, []string{"XMAS", "MAS", "SAM", "X", "AA", "XMASX", ""}},
+		{"Random", randomGrid(rand.New(rand.NewSource(1)), 40, 30), randomWords(rand.New(rand.NewSource(2)), 50)},
+		{"Ragged", [][]string{{"X", "M", "A", "S"}, {"M"}, {"A", "A", "A"}, {"S", "S", "S", "S", "S"}}, []string{"XMAS", "SA", "MA"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := make(map[string]int)
+			for _, word := range test.words {
+				expected[word] = len(searchWords(test.matrix, []string{word}, allDirections))
+			}
+			result := countWords(test.matrix, test.words)
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("countWords(%v) = %v; expected %v", test.words, result, expected)
+			}
+		})
+	}
+
+	if result := countWords(demo, []string{"XMAS"})["XMAS"]; result != 18 {
+		t.Errorf("countWords(XMAS) = %d; expected 18", result)
+	}
+}
+
+func randomGrid(r *rand.Rand, rows, cols int) [][]string {
+	letters := []string{"X", "M", "A", "S"}
+	matrix := make([][]string, rows)
+	for i := range matrix {
+		matrix[i] = make([]string, cols)
+		for j := range matrix[i] {
+			matrix[i][j] = letters[r.Intn(len(letters))]
+		}
+	}
+	return matrix
+}
+
+func randomWords(r *rand.Rand, n int) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for len(words) < n {
+		word := ""
+		for range 3 + r.Intn(6) {
+			word += string("XMAS"[r.Intn(4)])
+		}
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func BenchmarkSearchWords(b *testing.B) {
+	matrix := randomGrid(rand.New(rand.NewSource(1)), 1000, 1000)
+	words := randomWords(rand.New(rand.NewSource(2)), 200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searchWords(matrix, words, allDirections)
+	}
+}
+
+func BenchmarkCountWords(b *testing.B) {
+	matrix := randomGrid(rand.New(rand.NewSource(1)), 1000, 1000)
+	words := randomWords(rand.New(rand.NewSource(2)), 200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		countWords(matrix, words)
+	}
+}
+
+const demoGrid = `MMMSXXMASM
+MSAMXMSMSA
+AMXSXMAAMM
+MSAMASMSMX
+XMASAMXAMM
+XXAMMXXAMA
+SMSMSASXSS
+SAXAMASAAA
+MAMMMXMMMM
+MXMXAXMASX`
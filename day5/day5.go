@@ -1,17 +1,18 @@
 package main
 
 import (
+	"container/heap"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime/pprof"
 	"slices"
 	"strconv"
 	"strings"
-	"time"
-
-	"golang.org/x/exp/rand"
 )
 
 const (
@@ -20,15 +21,46 @@ const (
 )
 
 var (
-	demo        = flag.Bool("demo", false, "Use demo input")
-	enablePprof = flag.Bool("pprof", false, "Enable pprof")
-	verbose     = flag.Bool("v", false, "Enable verbose output")
+	demo         = flag.Bool("demo", false, "Use demo input")
+	enablePprof  = flag.Bool("pprof", false, "Enable pprof")
+	verbose      = flag.Bool("v", false, "Enable verbose output")
+	graphOut     = flag.String("graph", "", "Write the rule graph to this file")
+	graphFormat  = flag.String("graph-format", "dot", "Rule graph format (dot or mermaid)")
+	graphUpdate  = flag.Int("graph-update", 0, "Restrict the rule graph to the pages of this update (1-based) and highlight the rules it breaks")
+	reportFormat = flag.String("report", "", "Print a per update report (text or json)")
 )
 
 type rule struct {
 	l, r int
 }
 
+func (r rule) String() string {
+	return fmt.Sprintf("%d|%d", r.l, r.r)
+}
+
+func (r rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// updateReport describes how an update fares against the rules. An invalid
+// update whose rules contradict each other has the Cycle among its pages in
+// place of a correction, and so no MiddleAfter either.
+type updateReport struct {
+	Update       []int  `json:"update"`
+	Valid        bool   `json:"valid"`
+	Violated     []rule `json:"violated,omitempty"`
+	Cycle        []int  `json:"cycle,omitempty"`
+	Corrected    []int  `json:"corrected,omitempty"`
+	MiddleBefore int    `json:"middleBefore"`
+	MiddleAfter  *int   `json:"middleAfter,omitempty"`
+}
+
+type report struct {
+	Updates  []updateReport `json:"updates"`
+	Part1Sum int            `json:"part1Sum"`
+	Part2Sum int            `json:"part2Sum"`
+}
+
 func main() {
 	flag.Parse()
 
@@ -54,11 +86,33 @@ func main() {
 		log.Fatal(err)
 	}
 
-	rules, updates, err := parseInput(input)
+	rules, updates, err := parseInput(input, true)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *verbose {
+		for _, cycle := range findCycles(rules, nil) {
+			fmt.Printf("Rules contain cycle %s\n", formatCycle(cycle))
+		}
+	}
+
+	if *graphOut != "" {
+		if err := writeRuleGraphFile(*graphOut, *graphFormat, rules, updates, *graphUpdate); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *reportFormat != "" {
+		r, err := buildReport(rules, updates)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := writeReport(os.Stdout, *reportFormat, r); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	sum, err := computePart1(rules, updates)
 	if err != nil {
 		log.Fatal(err)
@@ -66,10 +120,13 @@ func main() {
 
 	fmt.Printf("Part1 sum: %d\n", sum)
 
-	sum, err = computePart2(rules, updates)
+	sum, skipped, err := computePart2(rules, updates)
 	if err != nil {
 		log.Fatal(err)
 	}
+	for _, err := range skipped {
+		fmt.Printf("Skipped %s\n", err)
+	}
 
 	fmt.Printf("Part2 sum: %d\n", sum)
 }
@@ -82,126 +139,549 @@ func readInputFile(file string) (string, error) {
 	return string(bytes), nil
 }
 
-func parseInput(input string) ([]rule, [][]int, error) {
+// parseInput reads the rules section, a blank line, then the updates section.
+// Every update must list distinct pages and, if requireMiddle is set, an odd
+// number of them.
+func parseInput(input string, requireMiddle bool) ([]rule, [][]int, error) {
 	var rules []rule
 	var updates [][]int
-	for _, line := range strings.Split(input, "\n") {
-		if strings.Contains(line, "|") {
-			rawRule := strings.Split(line, "|")
-			l, err := strconv.Atoi(rawRule[0])
-			if err != nil {
-				return nil, nil, err
-			}
-			r, err := strconv.Atoi(rawRule[1])
-			if err != nil {
-				return nil, nil, err
-			}
-			rule := rule{
-				l: l,
-				r: r,
-			}
-			rules = append(rules, rule)
+	lines := strings.Split(strings.TrimRight(input, "\r\n"), "\n")
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		if line == "" {
+			break
 		}
-		if strings.Contains(line, ",") {
-			newUpdates, err := stringListToIntList(strings.Split(line, ","))
-			if err != nil {
-				return nil, nil, err
-			}
-			updates = append(updates, newUpdates)
+		rule, err := parseRule(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	if i == len(lines) {
+		return nil, nil, fmt.Errorf("line %d: missing blank line between rules and updates", i+1)
+	}
+	for i++; i < len(lines); i++ {
+		update, err := parseUpdate(strings.TrimSuffix(lines[i], "\r"), requireMiddle)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", i+1, err)
 		}
-		continue
+		updates = append(updates, update)
 	}
 	return rules, updates, nil
 }
 
-func stringListToIntList(strList []string) ([]int, error) {
-	var intList []int
-	for _, str := range strList {
-		i, err := strconv.Atoi(str)
+func parseRule(line string) (rule, error) {
+	rawRule := strings.Split(line, "|")
+	if len(rawRule) != 2 {
+		return rule{}, fmt.Errorf("invalid rule %q: expected two pages separated by |", line)
+	}
+	l, err := parsePage(rawRule[0])
+	if err != nil {
+		return rule{}, fmt.Errorf("invalid rule %q: %w", line, err)
+	}
+	r, err := parsePage(rawRule[1])
+	if err != nil {
+		return rule{}, fmt.Errorf("invalid rule %q: %w", line, err)
+	}
+	if l == r {
+		// No order of pages could satisfy it.
+		return rule{}, fmt.Errorf("invalid rule %q: page %d cannot come before itself", line, l)
+	}
+	return rule{
+		l: l,
+		r: r,
+	}, nil
+}
+
+func parseUpdate(line string, requireMiddle bool) ([]int, error) {
+	if line == "" {
+		return nil, fmt.Errorf("empty update")
+	}
+	var update []int
+	seen := make(map[int]bool)
+	for _, rawPage := range strings.Split(line, ",") {
+		page, err := parsePage(rawPage)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid update %q: %w", line, err)
+		}
+		if seen[page] {
+			return nil, fmt.Errorf("invalid update %q: page %d appears more than once", line, page)
 		}
-		intList = append(intList, i)
+		seen[page] = true
+		update = append(update, page)
+	}
+	if requireMiddle && len(update)%2 == 0 {
+		return nil, fmt.Errorf("invalid update %q: %d pages has no middle page", line, len(update))
+	}
+	return update, nil
+}
+
+func parsePage(s string) (int, error) {
+	page, err := strconv.Atoi(s)
+	if err != nil || page < 0 {
+		return 0, fmt.Errorf("invalid page number %q", s)
 	}
-	return intList, nil
+	return page, nil
 }
 
 func computePart1(rules []rule, updates [][]int) (int, error) {
 	var sum int
-nextUpdate:
+	index := newRuleIndex(rules)
 	for _, update := range updates {
-		satisfiesRules := true
-		for _, rule := range rules {
-			lValid := slices.Index(update, rule.l)
-			rValid := slices.Index(update, rule.r)
-			if lValid == -1 || rValid == -1 {
-				continue
+		if !index.satisfied(update) {
+			if *verbose {
+				fmt.Printf("Update %v does not satisfy the rules\n", update)
 			}
-			if lValid < rValid {
-				if *verbose {
-					fmt.Printf("Update %v satisfies rule %v\n", update, rule)
-				}
-			} else {
-				satisfiesRules = false
-				if *verbose {
-					fmt.Printf("Update %v does not satisfy rule %v\n", update, rule)
+			continue
+		}
+		if *verbose {
+			fmt.Printf("Update %v satisfies the rules\n", update)
+		}
+		sum += update[len(update)/2]
+	}
+	return sum, nil
+}
+
+// computePart2 sums the middle pages of the reordered invalid updates.
+// Updates whose rules contradict each other cannot be reordered; they are left
+// out of the sum and returned as skipped.
+func computePart2(rules []rule, updates [][]int) (int, []*cycleError, error) {
+	var sum int
+	var skipped []*cycleError
+	index := newRuleIndex(rules)
+	for _, update := range updates {
+		if index.satisfied(update) {
+			continue
+		}
+		fixed, err := index.fixUpdate(update)
+		var cycleErr *cycleError
+		if errors.As(err, &cycleErr) {
+			skipped = append(skipped, cycleErr)
+			continue
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if *verbose {
+			fmt.Printf("Update %v reordered to %v\n", update, fixed)
+		}
+		sum += fixed[len(fixed)/2]
+	}
+	return sum, skipped, nil
+}
+
+// ruleIndex answers whether one page must come before another. Pages are
+// given dense ids and each keeps the sorted ids of the pages that must come
+// after it, so the index grows with the rules rather than with the pages.
+type ruleIndex struct {
+	rules []rule
+	ids   map[int]int32
+	after [][]int32
+}
+
+func newRuleIndex(rules []rule) *ruleIndex {
+	index := &ruleIndex{
+		rules: rules,
+		ids:   make(map[int]int32),
+	}
+	for _, rule := range rules {
+		l, r := index.id(rule.l), index.id(rule.r)
+		index.after[l] = append(index.after[l], r)
+	}
+	for i, after := range index.after {
+		slices.Sort(after)
+		index.after[i] = slices.Compact(after)
+	}
+	return index
+}
+
+func (index *ruleIndex) id(page int) int32 {
+	id, ok := index.ids[page]
+	if !ok {
+		id = int32(len(index.after))
+		index.ids[page] = id
+		index.after = append(index.after, nil)
+	}
+	return id
+}
+
+// before reports whether a rule requires page l to come before page r.
+func (index *ruleIndex) before(l, r int) bool {
+	lID, ok := index.ids[l]
+	if !ok {
+		return false
+	}
+	rID, ok := index.ids[r]
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(index.after[lID], rID)
+	return found
+}
+
+// satisfied reports whether update breaks no rule, that is whether no page
+// has a page that must come after it printed earlier. Each page is looked up
+// once, and only pages with rules of their own are searched for the pages
+// before them, so the cost depends on the update and not on how many pages
+// the rules mention.
+func (index *ruleIndex) satisfied(update []int) bool {
+	var buf [32]int32
+	seen := buf[:0]
+	for _, page := range update {
+		id, ok := index.ids[page]
+		if !ok {
+			continue
+		}
+		if after := index.after[id]; len(after) > 0 {
+			for _, earlier := range seen {
+				if _, found := slices.BinarySearch(after, earlier); found {
+					return false
 				}
-				continue nextUpdate
 			}
 		}
-		if satisfiesRules {
-			sum += update[len(update)/2]
+		seen = append(seen, id)
+	}
+	return true
+}
+
+// fixUpdate returns a copy of update ordered so that it satisfies every rule
+// between its pages. It is a stable topological sort: whenever several pages
+// are free to come next, the one appearing earliest in update is chosen, so
+// the result is deterministic and keeps unconstrained pages in place.
+func (index *ruleIndex) fixUpdate(update []int) ([]int, error) {
+	after := make([][]int, len(update))
+	inDegree := make([]int, len(update))
+	for i, l := range update {
+		for j, r := range update {
+			if index.before(l, r) {
+				after[i] = append(after[i], j)
+				inDegree[j]++
+			}
+		}
+	}
+
+	ready := &intHeap{}
+	for i, degree := range inDegree {
+		if degree == 0 {
+			heap.Push(ready, i)
 		}
 	}
-	return sum, nil
+	fixed := make([]int, 0, len(update))
+	for ready.Len() > 0 {
+		i := heap.Pop(ready).(int)
+		fixed = append(fixed, update[i])
+		for _, j := range after[i] {
+			inDegree[j]--
+			if inDegree[j] == 0 {
+				heap.Push(ready, j)
+			}
+		}
+	}
+	if len(fixed) != len(update) {
+		cycles := findCycles(index.rules, update)
+		if len(cycles) == 0 {
+			// Only a page repeated within the update can cause this.
+			return nil, fmt.Errorf("update %v cannot be ordered", update)
+		}
+		return nil, &cycleError{update: update, cycle: cycles[0]}
+	}
+	return fixed, nil
 }
 
-func computePart2(rules []rule, updates [][]int) (int, error) {
-	var badUpdates [][]int
-nextUpdate:
+func buildReport(rules []rule, updates [][]int) (report, error) {
+	var r report
+	index := newRuleIndex(rules)
 	for _, update := range updates {
-		for _, rule := range rules {
-			lValid := slices.Index(update, rule.l)
-			rValid := slices.Index(update, rule.r)
-			if lValid == -1 || rValid == -1 {
+		u := updateReport{
+			Update:       update,
+			Valid:        index.satisfied(update),
+			Corrected:    update,
+			MiddleBefore: update[len(update)/2],
+		}
+		if u.Valid {
+			r.Part1Sum += u.MiddleBefore
+		} else {
+			u.Violated = violatedRules(rules, update)
+			fixed, err := index.fixUpdate(update)
+			var cycleErr *cycleError
+			if errors.As(err, &cycleErr) {
+				u.Cycle = cycleErr.cycle
+				u.Corrected = nil
+				r.Updates = append(r.Updates, u)
 				continue
 			}
-			if lValid >= rValid {
-				// Collect bad updates.
-				badUpdates = append(badUpdates, update)
-				continue nextUpdate
+			if err != nil {
+				return report{}, err
 			}
+			u.Corrected = fixed
 		}
+		middle := u.Corrected[len(u.Corrected)/2]
+		u.MiddleAfter = &middle
+		if !u.Valid {
+			r.Part2Sum += middle
+		}
+		r.Updates = append(r.Updates, u)
 	}
-	for _, update := range badUpdates {
-		for range []int{0, 1, 2, 3, 4} {
-			shuffle(rules) // I'm so sorry.
-			for _, rule := range rules {
-				makeUpdateSatisfyRule(update, rule)
+	return r, nil
+}
 
+func writeReport(w io.Writer, format string, r report) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "text":
+		for i, u := range r.Updates {
+			if u.Valid {
+				fmt.Fprintf(w, "Update %d: %s valid, middle page %d\n", i+1, formatPages(u.Update), u.MiddleBefore)
+				continue
+			}
+			fmt.Fprintf(w, "Update %d: %s invalid, middle page %d\n", i+1, formatPages(u.Update), u.MiddleBefore)
+			for _, rule := range u.Violated {
+				fmt.Fprintf(w, "\tviolates %s\n", rule)
 			}
+			if u.Cycle != nil {
+				fmt.Fprintf(w, "\tcontradictory rules %s, skipped\n", formatCycle(u.Cycle))
+				continue
+			}
+			fmt.Fprintf(w, "\tcorrected to %s, middle page %d\n", formatPages(u.Corrected), *u.MiddleAfter)
 		}
+		fmt.Fprintf(w, "Part1 sum: %d\n", r.Part1Sum)
+		fmt.Fprintf(w, "Part2 sum: %d\n", r.Part2Sum)
+		return nil
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
 	}
+}
 
-	return computePart1(rules, badUpdates)
+func formatPages(pages []int) string {
+	strs := make([]string, len(pages))
+	for i, page := range pages {
+		strs[i] = strconv.Itoa(page)
+	}
+	return strings.Join(strs, ",")
+}
+
+// violatedRules returns the rules broken by update, in rule order.
+func violatedRules(rules []rule, update []int) []rule {
+	positions := make(map[int]int, len(update))
+	for i, page := range update {
+		if _, ok := positions[page]; !ok {
+			positions[page] = i
+		}
+	}
+	var violated []rule
+	for _, rule := range rules {
+		l, lOk := positions[rule.l]
+		r, rOk := positions[rule.r]
+		if lOk && rOk && l >= r {
+			violated = append(violated, rule)
+		}
+	}
+	return violated
 }
 
-func makeUpdateSatisfyRule(update []int, rule rule) []int {
-	lValid := slices.Index(update, rule.l)
-	rValid := slices.Index(update, rule.r)
-	if lValid == -1 || rValid == -1 {
-		return update
+func writeRuleGraphFile(file, format string, rules []rule, updates [][]int, updateNum int) error {
+	var update []int
+	if updateNum != 0 {
+		if updateNum < 1 || updateNum > len(updates) {
+			return fmt.Errorf("update %d out of range, there are %d updates", updateNum, len(updates))
+		}
+		update = updates[updateNum-1]
 	}
-	if lValid >= rValid {
-		update[lValid], update[rValid] = update[rValid], update[lValid]
+	graph, err := ruleGraph(format, rules, update)
+	if err != nil {
+		return err
 	}
-	return update
+	return os.WriteFile(file, []byte(graph), 0644)
 }
 
-func shuffle(slice []rule) {
-	r := rand.New(rand.NewSource(uint64(time.Now().Unix())))
-	for n := len(slice); n > 0; n-- {
-		randIndex := r.Intn(n)
-		slice[n-1], slice[randIndex] = slice[randIndex], slice[n-1]
+// ruleGraph renders the rules as a Graphviz DOT or Mermaid graph. If update is
+// non-nil only the rules between its pages are drawn, and the ones it breaks
+// are highlighted in red.
+func ruleGraph(format string, rules []rule, update []int) (string, error) {
+	var edges []rule
+	for _, rule := range rules {
+		if update == nil || (slices.Contains(update, rule.l) && slices.Contains(update, rule.r)) {
+			edges = append(edges, rule)
+		}
+	}
+	violated := violatedRules(edges, update)
+
+	var sb strings.Builder
+	switch format {
+	case "dot":
+		sb.WriteString("digraph rules {\n")
+		for _, page := range update {
+			fmt.Fprintf(&sb, "\t%d;\n", page)
+		}
+		for _, rule := range edges {
+			fmt.Fprintf(&sb, "\t%d -> %d", rule.l, rule.r)
+			if slices.Contains(violated, rule) {
+				sb.WriteString(" [color=red, penwidth=2]")
+			}
+			sb.WriteString(";\n")
+		}
+		sb.WriteString("}\n")
+	case "mermaid":
+		sb.WriteString("graph LR\n")
+		for _, page := range update {
+			fmt.Fprintf(&sb, "\tp%d[%d]\n", page, page)
+		}
+		for _, rule := range edges {
+			fmt.Fprintf(&sb, "\tp%d[%d] --> p%d[%d]\n", rule.l, rule.l, rule.r, rule.r)
+		}
+		for i, rule := range edges {
+			if slices.Contains(violated, rule) {
+				fmt.Fprintf(&sb, "\tlinkStyle %d stroke:red,stroke-width:2px\n", i)
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown graph format: %s", format)
 	}
+	return sb.String(), nil
+}
+
+// cycleError reports an update whose rules contradict each other.
+type cycleError struct {
+	update []int
+	cycle  []int
+}
+
+func (e *cycleError) Error() string {
+	return fmt.Sprintf("update %v has contradictory rules: %s", e.update, formatCycle(e.cycle))
+}
+
+func formatCycle(cycle []int) string {
+	pages := make([]string, len(cycle))
+	for i, page := range cycle {
+		pages[i] = strconv.Itoa(page)
+	}
+	return strings.Join(pages, " -> ")
+}
+
+// findCycles returns a cycle for every strongly connected component of the
+// rule graph, as the pages along it with the first page repeated at the end.
+// If pages is non-nil only rules between those pages are considered.
+func findCycles(rules []rule, pages []int) [][]int {
+	graph := make(map[int][]int)
+	for _, rule := range rules {
+		if pages != nil && (!slices.Contains(pages, rule.l) || !slices.Contains(pages, rule.r)) {
+			continue
+		}
+		graph[rule.l] = append(graph[rule.l], rule.r)
+		if _, ok := graph[rule.r]; !ok {
+			graph[rule.r] = nil
+		}
+	}
+	nodes := make([]int, 0, len(graph))
+	for node, next := range graph {
+		nodes = append(nodes, node)
+		slices.Sort(next)
+	}
+	slices.Sort(nodes)
+
+	var cycles [][]int
+	for _, component := range stronglyConnectedComponents(graph, nodes) {
+		if cycle := shortestCycle(graph, component); cycle != nil {
+			cycles = append(cycles, cycle)
+		}
+	}
+	return cycles
+}
+
+// stronglyConnectedComponents implements Tarjan's algorithm. Each component is
+// returned sorted, and components are ordered by their smallest page.
+func stronglyConnectedComponents(graph map[int][]int, nodes []int) [][]int {
+	index := make(map[int]int)
+	lowLink := make(map[int]int)
+	onStack := make(map[int]bool)
+	var stack []int
+	var components [][]int
+
+	var visit func(node int)
+	visit = func(node int) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, next := range graph[node] {
+			if _, ok := index[next]; !ok {
+				visit(next)
+				lowLink[node] = min(lowLink[node], lowLink[next])
+			} else if onStack[next] {
+				lowLink[node] = min(lowLink[node], index[next])
+			}
+		}
+		if lowLink[node] != index[node] {
+			return
+		}
+		var component []int
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == node {
+				break
+			}
+		}
+		slices.Sort(component)
+		components = append(components, component)
+	}
+	for _, node := range nodes {
+		if _, ok := index[node]; !ok {
+			visit(node)
+		}
+	}
+	slices.SortFunc(components, func(a, b []int) int { return a[0] - b[0] })
+	return components
+}
+
+// shortestCycle returns the shortest cycle through the smallest page of a
+// strongly connected component, or nil if the component has no cycle.
+func shortestCycle(graph map[int][]int, component []int) []int {
+	start := component[0]
+	parent := map[int]int{start: start}
+	queue := []int{start}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[node] {
+			if next == start {
+				cycle := []int{start}
+				for n := node; n != start; n = parent[n] {
+					cycle = append(cycle, n)
+				}
+				slices.Reverse(cycle[1:])
+				return append(cycle, start)
+			}
+			if _, seen := parent[next]; seen || !slices.Contains(component, next) {
+				continue
+			}
+			parent[next] = node
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+// intHeap is a min-heap of ints for use with container/heap.
+type intHeap []int
+
+func (h intHeap) Len() int           { return len(h) }
+func (h intHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *intHeap) Push(x any) {
+	*h = append(*h, x.(int))
+}
+
+func (h *intHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
 }
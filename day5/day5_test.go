@@ -1,19 +1,24 @@
 package main
 
 import (
+	"fmt"
+	"math/rand"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 )
 
 func TestParseInput(t *testing.T) {
 	tests := []struct {
 		input           string
+		requireMiddle   bool
 		expectedRules   []rule
 		expectedUpdates [][]int
-		expectError     bool
+		expectedError   string
 	}{
 		{
-			input: "1|2\n3,4,5\n6|7\n8,9,10",
+			input: "1|2\n6|7\n\n3,4,5\n8,9,10",
 			expectedRules: []rule{
 				{l: 1, r: 2},
 				{l: 6, r: 7},
@@ -22,16 +27,13 @@ func TestParseInput(t *testing.T) {
 				{3, 4, 5},
 				{8, 9, 10},
 			},
-			expectError: false,
 		},
 		{
-			input:           "1|a\n3,4,5",
-			expectedRules:   nil,
-			expectedUpdates: nil,
-			expectError:     true,
+			input:         "1|a\n\n3,4,5",
+			expectedError: `line 1: invalid rule "1|a": invalid page number "a"`,
 		},
 		{
-			input: "1|2\n3,4,5\n6|7\n8,9,10\n",
+			input: "1|2\n6|7\n\n3,4,5\n8,9,10\n",
 			expectedRules: []rule{
 				{l: 1, r: 2},
 				{l: 6, r: 7},
@@ -40,15 +42,65 @@ func TestParseInput(t *testing.T) {
 				{3, 4, 5},
 				{8, 9, 10},
 			},
-			expectError: false,
+		},
+		{
+			input:         "1|2\r\n\r\n7\r\n3,4\r\n",
+			expectedRules: []rule{{l: 1, r: 2}},
+			expectedUpdates: [][]int{
+				{7},
+				{3, 4},
+			},
+		},
+		{
+			input:           "\n5",
+			requireMiddle:   true,
+			expectedRules:   nil,
+			expectedUpdates: [][]int{{5}},
+		},
+		{
+			input:         "1|2\n3,4,5\n6|7\n8,9,10",
+			expectedError: `line 2: invalid rule "3,4,5": expected two pages separated by |`,
+		},
+		{
+			input:         "1|2\n6|7",
+			expectedError: "line 3: missing blank line between rules and updates",
+		},
+		{
+			input:         "1|2|3\n\n1,2,3",
+			expectedError: `line 1: invalid rule "1|2|3": expected two pages separated by |`,
+		},
+		{
+			input:         "1|2\n\n1,2,3\n\n4,5,6",
+			expectedError: "line 4: empty update",
+		},
+		{
+			input:         "1|2\n\n1,,3",
+			expectedError: `line 3: invalid update "1,,3": invalid page number ""`,
+		},
+		{
+			input:         "1|2\n5|5\n\n1,2",
+			expectedError: `line 2: invalid rule "5|5": page 5 cannot come before itself`,
+		},
+		{
+			input:         "1|2\n\n1,2,1",
+			expectedError: `line 3: invalid update "1,2,1": page 1 appears more than once`,
+		},
+		{
+			input:         "1|2\n\n1,2,3\n1,2",
+			requireMiddle: true,
+			expectedError: `line 4: invalid update "1,2": 2 pages has no middle page`,
+		},
+		{
+			input:         "-1|2\n\n1,2,3",
+			expectedError: `line 1: invalid rule "-1|2": invalid page number "-1"`,
 		},
 	}
 
 	for _, test := range tests {
-		rules, updates, err := parseInput(test.input)
-		if test.expectError {
-			if err == nil {
-				t.Errorf("Expected error but got none")
+		rules, updates, err := parseInput(test.input, test.requireMiddle)
+		if test.expectedError != "" {
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error %q for input %q but got %v", test.expectedError, test.input, err)
 			}
 		} else {
 			if err != nil {
@@ -155,6 +207,8 @@ func TestComputePart2(t *testing.T) {
 		{
 			rules: []rule{
 				{l: 1, r: 2},
+				{l: 1, r: 3},
+				{l: 3, r: 2},
 			},
 			updates: [][]int{
 				{2, 3, 1},
@@ -188,19 +242,34 @@ func TestComputePart2(t *testing.T) {
 		{
 			rules: []rule{
 				{l: 1, r: 2},
+				{l: 1, r: 3},
 				{l: 3, r: 4},
+				{l: 4, r: 2},
+				{l: 2, r: 7},
 			},
 			updates: [][]int{
 				{2, 4, 3, 1, 7},
 				{4, 3, 2, 1, 1},
 			},
-			expectedSum: 5,
+			expectedSum: 7,
+			expectError: false,
+		},
+		{
+			rules: []rule{
+				{l: 1, r: 2},
+				{l: 2, r: 3},
+				{l: 3, r: 1},
+			},
+			updates: [][]int{
+				{3, 2, 1},
+			},
+			expectedSum: 0,
 			expectError: false,
 		},
 	}
 
 	for _, test := range tests {
-		sum, err := computePart2(test.rules, test.updates)
+		sum, _, err := computePart2(test.rules, test.updates)
 		if test.expectError {
 			if err == nil {
 				t.Errorf("Expected error but got none")
@@ -215,43 +284,499 @@ func TestComputePart2(t *testing.T) {
 		}
 	}
 }
-func TestMakeUpdateSatisfyRule(t *testing.T) {
+
+func TestFixUpdate(t *testing.T) {
+	tests := []struct {
+		rules       []rule
+		update      []int
+		expected    []int
+		expectError bool
+	}{
+		{
+			rules:    []rule{{l: 1, r: 2}},
+			update:   []int{2, 1, 3},
+			expected: []int{1, 2, 3},
+		},
+		{
+			rules:    []rule{{l: 1, r: 2}},
+			update:   []int{2, 3, 1},
+			expected: []int{3, 1, 2},
+		},
+		{
+			rules:    []rule{{l: 6, r: 7}},
+			update:   []int{8, 7, 6},
+			expected: []int{8, 6, 7},
+		},
+		{
+			rules:    []rule{{l: 1, r: 2}, {l: 3, r: 4}},
+			update:   []int{4, 3, 2, 1, 1},
+			expected: []int{3, 4, 1, 1, 2},
+		},
+		{
+			rules:    []rule{{l: 5, r: 4}, {l: 4, r: 3}, {l: 3, r: 2}, {l: 2, r: 1}},
+			update:   []int{1, 2, 3, 4, 5},
+			expected: []int{5, 4, 3, 2, 1},
+		},
+		{
+			rules:       []rule{{l: 1, r: 2}, {l: 2, r: 1}},
+			update:      []int{1, 2},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		original := slices.Clone(test.update)
+		result, err := newRuleIndex(test.rules).fixUpdate(test.update)
+		if (err != nil) != test.expectError {
+			t.Errorf("fixUpdate(%v, %v) error = %v, expectError %v", test.rules, test.update, err, test.expectError)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("fixUpdate(%v, %v) = %v, expected %v", test.rules, test.update, result, test.expected)
+		}
+		if !reflect.DeepEqual(test.update, original) {
+			t.Errorf("fixUpdate(%v, %v) modified its input", test.rules, original)
+		}
+	}
+}
+
+func TestFindCycles(t *testing.T) {
 	tests := []struct {
-		update         []int
-		rule           rule
-		expectedUpdate []int
+		rules    []rule
+		pages    []int
+		expected [][]int
 	}{
 		{
-			update:         []int{1, 2, 3},
-			rule:           rule{l: 1, r: 2},
-			expectedUpdate: []int{1, 2, 3},
+			rules:    []rule{{l: 1, r: 2}, {l: 2, r: 3}, {l: 1, r: 3}},
+			expected: nil,
 		},
 		{
-			update:         []int{2, 1, 3},
-			rule:           rule{l: 1, r: 2},
-			expectedUpdate: []int{1, 2, 3},
+			rules:    []rule{{l: 1, r: 2}, {l: 2, r: 3}, {l: 3, r: 1}},
+			expected: [][]int{{1, 2, 3, 1}},
 		},
 		{
-			update:         []int{4, 3, 2, 1},
-			rule:           rule{l: 1, r: 2},
-			expectedUpdate: []int{4, 3, 1, 2},
+			rules:    []rule{{l: 3, r: 1}, {l: 1, r: 2}, {l: 2, r: 3}, {l: 1, r: 3}, {l: 3, r: 4}, {l: 5, r: 6}, {l: 6, r: 5}},
+			expected: [][]int{{1, 3, 1}, {5, 6, 5}},
 		},
 		{
-			update:         []int{4, 3, 2, 1},
-			rule:           rule{l: 3, r: 4},
-			expectedUpdate: []int{3, 4, 2, 1},
+			rules:    []rule{{l: 7, r: 7}},
+			expected: [][]int{{7, 7}},
 		},
 		{
-			update:         []int{4, 1, 3, 2},
-			rule:           rule{l: 1, r: 2},
-			expectedUpdate: []int{4, 1, 3, 2},
+			rules:    []rule{{l: 1, r: 2}, {l: 2, r: 3}, {l: 3, r: 1}},
+			pages:    []int{1, 2},
+			expected: nil,
+		},
+		{
+			rules:    []rule{{l: 1, r: 2}, {l: 2, r: 3}, {l: 3, r: 1}, {l: 2, r: 1}},
+			pages:    []int{2, 1, 4},
+			expected: [][]int{{1, 2, 1}},
 		},
 	}
 
 	for _, test := range tests {
-		result := makeUpdateSatisfyRule(test.update, test.rule)
-		if !reflect.DeepEqual(result, test.expectedUpdate) {
-			t.Errorf("Expected update %v, but got %v", test.expectedUpdate, result)
+		result := findCycles(test.rules, test.pages)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("findCycles(%v, %v) = %v, expected %v", test.rules, test.pages, result, test.expected)
+		}
+	}
+
+	rules, _, err := parseInput(demoInput, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cycles := findCycles(rules, nil); cycles != nil {
+		t.Errorf("Expected demo rules to be acyclic, but got %v", cycles)
+	}
+}
+
+func TestComputePart2SkipsContradictoryUpdates(t *testing.T) {
+	rules := []rule{{l: 1, r: 2}, {l: 2, r: 3}, {l: 3, r: 1}, {l: 4, r: 5}, {l: 6, r: 7}, {l: 7, r: 6}}
+	updates := [][]int{{5, 4, 9}, {4, 3, 2, 1, 5}, {7, 6, 5}}
+
+	sum, skipped, err := computePart2(rules, updates)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sum != 5 {
+		t.Errorf("Expected sum 5, but got %d", sum)
+	}
+	if len(skipped) != 2 {
+		t.Fatalf("Expected 2 skipped updates, but got %v", skipped)
+	}
+	if !reflect.DeepEqual(skipped[0].update, updates[1]) || !reflect.DeepEqual(skipped[0].cycle, []int{1, 2, 3, 1}) {
+		t.Errorf("Expected cycle 1 -> 2 -> 3 -> 1 in update %v, but got %v", updates[1], skipped[0])
+	}
+	if expected := "update [4 3 2 1 5] has contradictory rules: 1 -> 2 -> 3 -> 1"; skipped[0].Error() != expected {
+		t.Errorf("Expected error %q, but got %q", expected, skipped[0].Error())
+	}
+	if !reflect.DeepEqual(skipped[1].update, updates[2]) || !reflect.DeepEqual(skipped[1].cycle, []int{6, 7, 6}) {
+		t.Errorf("Expected cycle 6 -> 7 -> 6 in update %v, but got %v", updates[2], skipped[1])
+	}
+
+	r, err := buildReport(rules, updates)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r.Part2Sum != sum {
+		t.Errorf("Expected report part 2 sum %d, but got %d", sum, r.Part2Sum)
+	}
+	var cycles [][]int
+	for _, u := range r.Updates {
+		if u.Cycle != nil {
+			cycles = append(cycles, u.Cycle)
+			if u.Corrected != nil {
+				t.Errorf("Expected no correction for contradictory update %v, but got %v", u.Update, u.Corrected)
+			}
+		}
+	}
+	if expected := [][]int{{1, 2, 3, 1}, {6, 7, 6}}; !reflect.DeepEqual(cycles, expected) {
+		t.Errorf("Expected report cycles %v, but got %v", expected, cycles)
+	}
+
+	var sb strings.Builder
+	if err := writeReport(&sb, "text", report{Updates: r.Updates[2:]}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedText := `Update 1: 7,6,5 invalid, middle page 6
+	violates 6|7
+	contradictory rules 6 -> 7 -> 6, skipped
+Part1 sum: 0
+Part2 sum: 0
+`
+	if sb.String() != expectedText {
+		t.Errorf("Expected text report %q, but got %q", expectedText, sb.String())
+	}
+}
+
+func TestRuleIndex(t *testing.T) {
+	rules := []rule{{l: 1, r: 2}, {l: 2, r: 3}, {l: 100, r: 1}}
+	for i := 0; i < 70; i++ {
+		rules = append(rules, rule{l: 1000 + i, r: 1000 + i + 1})
+	}
+	index := newRuleIndex(rules)
+
+	for _, rule := range rules {
+		if !index.before(rule.l, rule.r) {
+			t.Errorf("Expected %d before %d", rule.l, rule.r)
 		}
+		if index.before(rule.r, rule.l) {
+			t.Errorf("Did not expect %d before %d", rule.r, rule.l)
+		}
+	}
+	if index.before(1, 3) || index.before(5, 6) {
+		t.Errorf("Unexpected transitive or unknown rule")
+	}
+
+	tests := []struct {
+		update   []int
+		expected bool
+	}{
+		{[]int{1, 2, 3}, true},
+		{[]int{1, 3, 2}, false},
+		{[]int{3, 1}, true},
+		{[]int{2, 5, 1}, false},
+		{[]int{100, 7, 8, 1, 9, 3}, true},
+		{[]int{1000, 1050, 1070, 1071}, true},
+		{[]int{1000, 1070, 1069}, false},
+		{[]int{1065, 4, 1064}, false},
+		{[]int{}, true},
+	}
+	for _, test := range tests {
+		if result := index.satisfied(test.update); result != test.expected {
+			t.Errorf("satisfied(%v) = %v, expected %v", test.update, result, test.expected)
+		}
+	}
+
+	if !newRuleIndex(nil).satisfied([]int{3, 2, 1}) {
+		t.Errorf("Expected update to satisfy an empty rule set")
+	}
+
+	// Checking an update must not cost anything in proportion to the number
+	// of pages the rules mention.
+	rules, updates := generateInput(100000, 100, 200000)
+	index = newRuleIndex(rules)
+	if allocs := testing.AllocsPerRun(10, func() {
+		for _, update := range updates {
+			index.satisfied(update)
+		}
+	}); allocs != 0 {
+		t.Errorf("satisfied allocated %v times, expected none", allocs)
+	}
+}
+
+func TestViolatedRules(t *testing.T) {
+	rules := []rule{{l: 1, r: 2}, {l: 3, r: 4}, {l: 2, r: 3}, {l: 5, r: 6}}
+	tests := []struct {
+		update   []int
+		expected []rule
+	}{
+		{[]int{1, 2, 3, 4}, nil},
+		{[]int{4, 3, 2, 1}, []rule{{l: 1, r: 2}, {l: 3, r: 4}, {l: 2, r: 3}}},
+		{[]int{2, 1, 5}, []rule{{l: 1, r: 2}}},
+	}
+
+	for _, test := range tests {
+		result := violatedRules(rules, test.update)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("violatedRules(%v) = %v, expected %v", test.update, result, test.expected)
+		}
+	}
+}
+
+func TestRuleGraph(t *testing.T) {
+	rules := []rule{{l: 1, r: 2}, {l: 3, r: 1}, {l: 2, r: 4}}
+	tests := []struct {
+		format      string
+		update      []int
+		expected    string
+		expectError bool
+	}{
+		{
+			format:   "dot",
+			expected: "digraph rules {\n\t1 -> 2;\n\t3 -> 1;\n\t2 -> 4;\n}\n",
+		},
+		{
+			format:   "dot",
+			update:   []int{1, 2, 3, 7},
+			expected: "digraph rules {\n\t1;\n\t2;\n\t3;\n\t7;\n\t1 -> 2;\n\t3 -> 1 [color=red, penwidth=2];\n}\n",
+		},
+		{
+			format:   "mermaid",
+			expected: "graph LR\n\tp1[1] --> p2[2]\n\tp3[3] --> p1[1]\n\tp2[2] --> p4[4]\n",
+		},
+		{
+			format:   "mermaid",
+			update:   []int{2, 1, 4},
+			expected: "graph LR\n\tp2[2]\n\tp1[1]\n\tp4[4]\n\tp1[1] --> p2[2]\n\tp2[2] --> p4[4]\n\tlinkStyle 0 stroke:red,stroke-width:2px\n",
+		},
+		{
+			format:      "svg",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		result, err := ruleGraph(test.format, rules, test.update)
+		if (err != nil) != test.expectError {
+			t.Errorf("ruleGraph(%s, %v) error = %v, expectError %v", test.format, test.update, err, test.expectError)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("ruleGraph(%s, %v) = %q, expected %q", test.format, test.update, result, test.expected)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	rules := []rule{{l: 1, r: 2}, {l: 3, r: 4}, {l: 2, r: 3}}
+	updates := [][]int{{1, 2, 3}, {3, 2, 1}, {9, 4, 3}}
+
+	r, err := buildReport(rules, updates)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := report{
+		Updates: []updateReport{
+			{Update: []int{1, 2, 3}, Valid: true, Corrected: []int{1, 2, 3}, MiddleBefore: 2, MiddleAfter: page(2)},
+			{Update: []int{3, 2, 1}, Violated: []rule{{l: 1, r: 2}, {l: 2, r: 3}}, Corrected: []int{1, 2, 3}, MiddleBefore: 2, MiddleAfter: page(2)},
+			{Update: []int{9, 4, 3}, Violated: []rule{{l: 3, r: 4}}, Corrected: []int{9, 3, 4}, MiddleBefore: 4, MiddleAfter: page(3)},
+		},
+		Part1Sum: 2,
+		Part2Sum: 5,
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("Expected report %+v, but got %+v", expected, r)
+	}
+
+	var sb strings.Builder
+	if err := writeReport(&sb, "text", r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedText := `Update 1: 1,2,3 valid, middle page 2
+Update 2: 3,2,1 invalid, middle page 2
+	violates 1|2
+	violates 2|3
+	corrected to 1,2,3, middle page 2
+Update 3: 9,4,3 invalid, middle page 4
+	violates 3|4
+	corrected to 9,3,4, middle page 3
+Part1 sum: 2
+Part2 sum: 5
+`
+	if sb.String() != expectedText {
+		t.Errorf("Expected text report %q, but got %q", expectedText, sb.String())
+	}
+
+	sb.Reset()
+	if err := writeReport(&sb, "json", report{Updates: expected.Updates[2:], Part2Sum: 3}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedJSON := `{
+  "updates": [
+    {
+      "update": [
+        9,
+        4,
+        3
+      ],
+      "valid": false,
+      "violated": [
+        "3|4"
+      ],
+      "corrected": [
+        9,
+        3,
+        4
+      ],
+      "middleBefore": 4,
+      "middleAfter": 3
+    }
+  ],
+  "part1Sum": 0,
+  "part2Sum": 3
+}
+`
+	if sb.String() != expectedJSON {
+		t.Errorf("Expected JSON report %q, but got %q", expectedJSON, sb.String())
+	}
+
+	if err := writeReport(&sb, "xml", r); err == nil {
+		t.Errorf("Expected error but got none")
+	}
+
+	// A corrected middle page of 0 is still reported, unlike the missing one
+	// of an update that cannot be corrected.
+	r, err = buildReport([]rule{{l: 0, r: 9}, {l: 1, r: 2}, {l: 2, r: 1}}, [][]int{{9, 4, 0}, {2, 1, 3}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sb.Reset()
+	if err := writeReport(&sb, "json", r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Count(sb.String(), `"middleAfter": 0`) != 1 || strings.Count(sb.String(), `"middleAfter"`) != 1 {
+		t.Errorf("Expected only a middleAfter of 0 in JSON report, but got %q", sb.String())
+	}
+}
+
+func page(p int) *int {
+	return &p
+}
+
+func TestComputeDemo(t *testing.T) {
+	rules, updates, err := parseInput(demoInput, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sum, err := computePart1(rules, updates)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sum != 143 {
+		t.Errorf("Expected part 1 sum 143, but got %d", sum)
+	}
+	// The old implementation shuffled the rules, so run part 2 repeatedly to
+	// make sure the answer never changes.
+	for range 100 {
+		sum, _, err := computePart2(rules, updates)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if sum != 123 {
+			t.Fatalf("Expected part 2 sum 123, but got %d", sum)
+		}
+	}
+}
+
+const demoInput = `47|53
+97|13
+97|61
+97|47
+75|29
+61|13
+75|53
+29|13
+97|29
+53|29
+61|53
+97|53
+61|29
+47|13
+75|47
+97|75
+47|61
+75|61
+47|29
+75|13
+53|13
+
+75,47,61,53,29
+97,61,53,29,13
+75,29,13
+75,97,47,61,53
+61,13,29
+97,13,75,29,47`
+
+// generateInput returns rules drawn from a random total order of pages and
+// updates made of distinct pages in random order.
+func generateInput(numRules, numUpdates, numPages int) ([]rule, [][]int) {
+	r := rand.New(rand.NewSource(1))
+	order := r.Perm(numPages)
+
+	seen := make(map[rule]bool)
+	var rules []rule
+	for len(rules) < numRules {
+		i, j := r.Intn(numPages), r.Intn(numPages)
+		if i >= j {
+			continue
+		}
+		rule := rule{l: order[i] + 10, r: order[j] + 10}
+		if !seen[rule] {
+			seen[rule] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	updates := make([][]int, numUpdates)
+	for i := range updates {
+		pages := make([]int, 0, 5+2*r.Intn(10))
+		for len(pages) < cap(pages) {
+			if page := r.Intn(numPages) + 10; !slices.Contains(pages, page) {
+				pages = append(pages, page)
+			}
+		}
+		updates[i] = pages
+	}
+	return rules, updates
+}
+
+// benchmarkPages are the numbers of distinct pages the rules are spread over.
+// The index must not grow with them.
+var benchmarkPages = []int{500, 5000, 20000, 200000}
+
+func BenchmarkComputePart1(b *testing.B) {
+	for _, pages := range benchmarkPages {
+		b.Run(fmt.Sprintf("pages=%d", pages), func(b *testing.B) {
+			rules, updates := generateInput(100000, 100000, pages)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				computePart1(rules, updates)
+			}
+		})
+	}
+}
+
+func BenchmarkComputePart2(b *testing.B) {
+	for _, pages := range benchmarkPages {
+		b.Run(fmt.Sprintf("pages=%d", pages), func(b *testing.B) {
+			rules, updates := generateInput(100000, 100000, pages)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				computePart2(rules, updates)
+			}
+		})
 	}
 }
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"runtime/pprof"
+	"slices"
 	"strings"
-	"time"
+	"sync"
 )
 
 const (
@@ -16,9 +26,14 @@ const (
 )
 
 var (
-	demo        = flag.Bool("demo", false, "Use demo input")
-	enablePprof = flag.Bool("pprof", false, "Enable pprof")
-	verbose     = flag.Bool("v", false, "Enable verbose output")
+	demo             = flag.Bool("demo", false, "Use demo input")
+	enablePprof      = flag.Bool("pprof", false, "Enable pprof")
+	verbose          = flag.Bool("v", false, "Enable verbose output")
+	workers          = flag.Int("workers", runtime.GOMAXPROCS(0), "Number of workers searching for new obstructions")
+	record           = flag.String("record", "", "Record the part one patrol to this .cast (asciinema) or .gif file")
+	frameSkip        = flag.Int("frame-skip", 1, "Record only every nth step of the patrol")
+	listObstructions = flag.String("obstructions", "", "Print the new obstructions as a list of row,col coordinates (list) or on the map (map)")
+	interactive      = flag.Bool("i", false, "Step through the patrol interactively")
 )
 
 func main() {
@@ -46,18 +61,82 @@ func main() {
 		log.Fatal(err)
 	}
 
-	m, startingCol, startingRow, err := parseMap(input)
+	m, guards, err := parseMap(input)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	positions := traverse(m, startingCol, startingRow, 0, 0, 0)
+	if err := checkSingleGuardFlags(len(guards)); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(guards) > 1 {
+		for i, result := range simulateGuards(m, guards) {
+			fmt.Printf("Guard %d (%s at %d, %d): %d unique positions, %v\n", i+1, charForDirection(guards[i].direction), guards[i].row, guards[i].col, result.positions, result.outcome)
+		}
+		return
+	}
+
+	if *interactive {
+		restore, err := makeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read single key presses (%v), end each command with Enter\n", err)
+			restore = func() {}
+		}
+		err = runStepper(newStepper(m, guards[0]), os.Stdin, os.Stdout)
+		restore()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var rec *recorder
+	if *record != "" {
+		rec, err = newFileRecorder(*record, m, *frameSkip)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	positions, _ := traverse(m, guards[0], rec)
+
+	if err := rec.close(); err != nil {
+		log.Fatal(err)
+	}
 
 	fmt.Printf("Unique Positions: %d\n", positions)
 
-	blocks := part2(m, startingCol, startingRow)
+	obstructions, err := loopObstructions(m, guards[0], *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("New Blocks: %d\n", len(obstructions))
 
-	fmt.Printf("New Blocks: %d\n", blocks)
+	if *listObstructions != "" {
+		if err := writeObstructions(os.Stdout, *listObstructions, m, guards[0], obstructions); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// checkSingleGuardFlags returns an error if the map has several guards and a
+// flag is set that only works on the patrol of a single guard.
+func checkSingleGuardFlags(guards int) error {
+	if guards <= 1 {
+		return nil
+	}
+	if *record != "" {
+		return fmt.Errorf("-record needs a map with a single guard, found %d", guards)
+	}
+	if *interactive {
+		return fmt.Errorf("-i needs a map with a single guard, found %d", guards)
+	}
+	if *listObstructions != "" {
+		return fmt.Errorf("-obstructions needs a map with a single guard, found %d", guards)
+	}
+	return nil
 }
 
 func readInputFile(file string) (string, error) {
@@ -68,22 +147,42 @@ func readInputFile(file string) (string, error) {
 	return string(bytes), nil
 }
 
-func parseMap(input string) ([][]string, int, int, error) {
+// guard is where a guard starts and which way it is heading.
+type guard struct {
+	row, col  int
+	direction string
+}
+
+// glyphDirections maps the glyphs marking a guard to its heading.
+var glyphDirections = map[string]string{
+	"^": "up",
+	">": "right",
+	"v": "down",
+	"<": "left",
+}
+
+// parseMap returns the map and every guard on it, in reading order.
+func parseMap(input string) ([][]string, []guard, error) {
 	rows := strings.Split(input, "\n")
 	m := make([][]string, len(rows))
-	startingCol := 0
-	startingRow := 0
+	var guards []guard
 	for i, row := range rows {
 		cols := strings.Split(row, "")
 		m[i] = cols
 		for j, col := range cols {
-			if col == "^" {
-				startingCol = j
-				startingRow = i
+			if direction, ok := glyphDirections[col]; ok {
+				guards = append(guards, guard{
+					row:       i,
+					col:       j,
+					direction: direction,
+				})
 			}
 		}
 	}
-	return m, startingCol, startingRow, nil
+	if len(guards) == 0 {
+		return nil, nil, fmt.Errorf("no guard found in map")
+	}
+	return m, guards, nil
 }
 
 func printMap(m [][]string) {
@@ -93,60 +192,86 @@ func printMap(m [][]string) {
 	fmt.Println()
 }
 
-func clear() {
-	fmt.Print("\033[H\033[2J")
+// outcome describes how a patrol ends.
+type outcome int
+
+const (
+	// exited means the guard walked off the map.
+	exited outcome = iota
+	// looped means the guard returned to a position it had already been in
+	// facing the same direction, so it will patrol forever.
+	looped
+)
+
+func (o outcome) String() string {
+	switch o {
+	case exited:
+		return "exited"
+	case looped:
+		return "looped"
+	}
+	return ""
+}
+
+// directionBits gives each direction a bit for recording visited states.
+var directionBits = map[string]uint8{
+	"up":    1,
+	"right": 2,
+	"down":  4,
+	"left":  8,
 }
 
-func traverse(m [][]string, startingCol, startingRow, traversals, newCol, newRol int) int {
+// traverse walks the guard from the starting position and returns the number
+// of unique positions visited and whether the guard left the map or looped.
+// Every step is captured by rec unless it is nil.
+func traverse(m [][]string, start guard, rec *recorder) (int, outcome) {
 	m = deepCopyMap(m)
-	uniquePositions := 0
-	sequentialRevisits := 0
+	uniquePositions := 1
 
-	currCol := startingCol
-	currRow := startingRow
-	direction := "up"
+	currCol := start.col
+	currRow := start.row
+	direction := start.direction
 
-	if *verbose {
-		printMap(m)
+	// visited holds a bit for every direction the guard has faced in a cell.
+	visited := make([][]uint8, len(m))
+	for i, row := range m {
+		visited[i] = make([]uint8, len(row))
 	}
+	visited[currRow][currCol] = directionBits[direction]
 
+	result := exited
 	for {
+		rec.capture(m)
 		nextRow, nextCol := nextPos(direction, currRow, currCol)
-		if *verbose {
-			clear()
-			fmt.Printf("Traversals: %d\n", traversals)
-			fmt.Printf("New Blocker: %d, %d\n", newCol, newRol)
-			fmt.Printf("Curr: %d, %d, Next: %d, %d, Uniq: %d, Sequential: %d\n", currRow, currCol, nextRow, nextCol, uniquePositions, sequentialRevisits)
-			printMap(m)
-		}
-		if nextRow < 0 || nextRow >= len(m) || nextCol < 0 || nextCol >= len(m[0]) {
-			uniquePositions++
+		if nextRow < 0 || nextRow >= len(m) || nextCol < 0 || nextCol >= len(m[nextRow]) {
+			m[currRow][currCol] = "X"
 			break
 		}
 		if m[nextRow][nextCol] == "#" {
 			direction = turn(direction)
-			continue
-		}
-		if m[nextRow][nextCol] == "X" {
-			sequentialRevisits++
-			if sequentialRevisits == 100 {
-				// Loop detected.
-				return -1
-			}
+			m[currRow][currCol] = charForDirection(direction)
 		} else {
-			sequentialRevisits = 0
-			uniquePositions++
+			m[currRow][currCol] = "X"
+			currCol = nextCol
+			currRow = nextRow
+			m[nextRow][nextCol] = charForDirection(direction)
+			if visited[currRow][currCol] == 0 {
+				uniquePositions++
+			}
 		}
-		m[currRow][currCol] = "X"
-		currCol = nextCol
-		currRow = nextRow
-		m[nextRow][nextCol] = charForDirection(direction)
-		if *verbose {
-			time.Sleep(10 * time.Millisecond)
+		bit := directionBits[direction]
+		if visited[currRow][currCol]&bit != 0 {
+			result = looped
+			break
 		}
+		visited[currRow][currCol] |= bit
 	}
 
-	return uniquePositions
+	rec.finish(m)
+	if *verbose {
+		printMap(m)
+	}
+	return uniquePositions, result
 }
 
 func nextPos(direction string, currRow, currCol int) (int, int) {
@@ -191,41 +316,857 @@ func charForDirection(direction string) string {
 	return ""
 }
 
-func part2(m [][]string, startingCol, startingRow int) int {
+// position is a cell of the map.
+type position struct {
+	row, col int
+}
+
+// guardPath returns every position the guard visits, in the order first
+// visited, for a patrol that leaves the map.
+func guardPath(m [][]string, start guard) []position {
+	seen := make(map[position]bool)
+	curr := position{start.row, start.col}
+	direction := start.direction
+	var path []position
+	for {
+		if !seen[curr] {
+			seen[curr] = true
+			path = append(path, curr)
+		}
+		nextRow, nextCol := nextPos(direction, curr.row, curr.col)
+		if nextRow < 0 || nextRow >= len(m) || nextCol < 0 || nextCol >= len(m[nextRow]) {
+			return path
+		}
+		if m[nextRow][nextCol] == "#" {
+			direction = turn(direction)
+			continue
+		}
+		curr = position{nextRow, nextCol}
+	}
+}
+
+// patrol returns the guard after every step, where a step is either a move or
+// a turn. If the guard loops, the path ends just before the first repeated
+// state and loopStart is the step at which the loop is entered; otherwise the
+// path ends with the guard's last position on the map and loopStart is -1.
+func patrol(m [][]string, start guard) (path []guard, loopStart int, result outcome) {
+	seen := make(map[guard]int)
+	curr := start
+	for {
+		if step, ok := seen[curr]; ok {
+			return path, step, looped
+		}
+		seen[curr] = len(path)
+		path = append(path, curr)
+		nextRow, nextCol := nextPos(curr.direction, curr.row, curr.col)
+		if nextRow < 0 || nextRow >= len(m) || nextCol < 0 || nextCol >= len(m[nextRow]) {
+			return path, -1, exited
+		}
+		if m[nextRow][nextCol] == "#" {
+			curr.direction = turn(curr.direction)
+		} else {
+			curr.row, curr.col = nextRow, nextCol
+		}
+	}
+}
+
+// part2 counts the positions where a single new obstruction makes the guard
+// loop.
+func part2(m [][]string, start guard, workers int) (int, error) {
+	obstructions, err := loopObstructions(m, start, workers)
+	return len(obstructions), err
+}
+
+var errAlreadyLoops = errors.New("the guard already patrols in a loop, so no new obstruction is needed")
+
+// loopObstructions returns the positions where a single new obstruction makes
+// the guard loop, in row-major order. Only positions on the guard's original
+// path can change its patrol, so only those are tried, spread across a pool
+// of workers. If the guard loops without a new obstruction, the question has
+// no answer and errAlreadyLoops is returned.
+func loopObstructions(m [][]string, start guard, workers int) ([]position, error) {
+	if _, result := traverse(m, start, nil); result == looped {
+		return nil, errAlreadyLoops
+	}
+
+	var candidates []position
+	for _, p := range guardPath(m, start) {
+		if m[p.row][p.col] == "." {
+			candidates = append(candidates, p)
+		}
+	}
+
+	g := newGrid(m)
+	startIndex := g.index(start.row, start.col)
+	startDirection := directionIndex[start.direction]
+	jobs := make(chan int)
+	loops := make([]bool, len(candidates))
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workerGrid := g.clone()
+			for i := range jobs {
+				p := candidates[i]
+				undo := workerGrid.addObstruction(p.row, p.col)
+				loops[i] = workerGrid.loops(startIndex, startDirection)
+				undo()
+			}
+		}()
+	}
+	for i := range candidates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if *verbose {
+		fmt.Printf("Traversals: %d\n", len(candidates))
+	}
+
+	var obstructions []position
+	for i, loop := range loops {
+		if loop {
+			obstructions = append(obstructions, candidates[i])
+		}
+	}
+	slices.SortFunc(obstructions, func(a, b position) int {
+		if a.row != b.row {
+			return a.row - b.row
+		}
+		return a.col - b.col
+	})
+	return obstructions, nil
+}
+
+// obstructionLoop describes the loop caused by a new obstruction, measured in
+// steps as returned by patrol.
+type obstructionLoop struct {
+	position
+	loopStart  int
+	loopLength int
+}
+
+// describeLoops replays the patrol with each of the obstructions in turn.
+func describeLoops(m [][]string, start guard, obstructions []position) []obstructionLoop {
 	m = deepCopyMap(m)
-	blocks := 0
-	traversals := 0
+	loops := make([]obstructionLoop, len(obstructions))
+	for i, p := range obstructions {
+		old := m[p.row][p.col]
+		m[p.row][p.col] = "#"
+		path, loopStart, _ := patrol(m, start)
+		m[p.row][p.col] = old
+		loops[i] = obstructionLoop{p, loopStart, len(path) - loopStart}
+	}
+	return loops
+}
+
+// writeObstructions writes the loop-causing obstructions either as a list of
+// row,col coordinates with their loops, or as the map with every obstruction
+// marked O.
+func writeObstructions(w io.Writer, format string, m [][]string, start guard, obstructions []position) error {
+	switch format {
+	case "list":
+		for _, l := range describeLoops(m, start, obstructions) {
+			if _, err := fmt.Fprintf(w, "%d,%d: loop of %d steps entered at step %d\n", l.row, l.col, l.loopLength, l.loopStart); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "map":
+		m = deepCopyMap(m)
+		for _, p := range obstructions {
+			m[p.row][p.col] = "O"
+		}
+		for _, row := range m {
+			if _, err := fmt.Fprintln(w, strings.Join(row, "")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown obstructions format %q", format)
+}
+
+// Directions used by grid, in the order the guard turns through them.
+const (
+	dirUp = iota
+	dirRight
+	dirDown
+	dirLeft
+)
+
+var directionIndex = map[string]int{
+	"up":    dirUp,
+	"right": dirRight,
+	"down":  dirDown,
+	"left":  dirLeft,
+}
+
+// grid is a compact form of the map for fast loop checks. For every cell and
+// direction it stores where the guard would next stop, so a patrol can be
+// simulated one turn at a time instead of one step at a time.
+type grid struct {
+	rows, cols int
+	blocked    []bool
+	// jump[d][i] is the cell where a guard at cell i facing d stops in front
+	// of an obstruction, or -1 if it walks off the map first.
+	jump [4][]int32
+
+	// seen[d][i] == generation when the guard has turned at cell i to face d
+	// during the current loop check.
+	seen       [4][]uint32
+	generation uint32
+}
 
+func newGrid(m [][]string) *grid {
+	g := &grid{rows: len(m)}
+	for _, row := range m {
+		g.cols = max(g.cols, len(row))
+	}
+	g.blocked = make([]bool, g.rows*g.cols)
 	for i, row := range m {
-		for j, col := range row {
-			set := false
-			if col != "." {
+		for j, cell := range row {
+			g.blocked[g.index(i, j)] = cell == "#"
+		}
+	}
+	for d := range g.jump {
+		g.jump[d] = make([]int32, len(g.blocked))
+		g.seen[d] = make([]uint32, len(g.blocked))
+	}
+	for i := range g.rows {
+		stop := int32(-1)
+		for j := range g.cols {
+			stop = g.scan(dirLeft, i, j, stop)
+		}
+		stop = -1
+		for j := g.cols - 1; j >= 0; j-- {
+			stop = g.scan(dirRight, i, j, stop)
+		}
+	}
+	for j := range g.cols {
+		stop := int32(-1)
+		for i := range g.rows {
+			stop = g.scan(dirUp, i, j, stop)
+		}
+		stop = -1
+		for i := g.rows - 1; i >= 0; i-- {
+			stop = g.scan(dirDown, i, j, stop)
+		}
+	}
+	return g
+}
+
+// scan fills in the jump for cell i, j, walking against direction d. stop is
+// where the guard stops for the previous cell of the walk.
+func (g *grid) scan(d, i, j int, stop int32) int32 {
+	if g.blocked[g.index(i, j)] {
+		backRow, backCol := step(d, i, j, -1)
+		return int32(g.index(backRow, backCol))
+	}
+	g.jump[d][g.index(i, j)] = stop
+	return stop
+}
+
+func (g *grid) index(row, col int) int {
+	return row*g.cols + col
+}
+
+func (g *grid) inBounds(row, col int) bool {
+	return row >= 0 && row < g.rows && col >= 0 && col < g.cols
+}
+
+func step(d, row, col, n int) (int, int) {
+	switch d {
+	case dirUp:
+		return row - n, col
+	case dirRight:
+		return row, col + n
+	case dirDown:
+		return row + n, col
+	case dirLeft:
+		return row, col - n
+	}
+	return row, col
+}
+
+func (g *grid) clone() *grid {
+	c := &grid{
+		rows:    g.rows,
+		cols:    g.cols,
+		blocked: slices.Clone(g.blocked),
+	}
+	for d := range g.jump {
+		c.jump[d] = slices.Clone(g.jump[d])
+		c.seen[d] = make([]uint32, len(g.seen[d]))
+	}
+	return c
+}
+
+// addObstruction blocks the cell at row, col, updating only the jumps of the
+// cells that now stop in front of it. The returned function removes it again.
+func (g *grid) addObstruction(row, col int) func() {
+	type change struct {
+		d, i int
+		old  int32
+	}
+	var changes []change
+	for d := range g.jump {
+		// Cells approaching the obstruction while facing d lie behind it.
+		stopRow, stopCol := step(d, row, col, -1)
+		if !g.inBounds(stopRow, stopCol) {
+			continue
+		}
+		stop := int32(g.index(stopRow, stopCol))
+		for r, c := stopRow, stopCol; g.inBounds(r, c) && !g.blocked[g.index(r, c)]; r, c = step(d, r, c, -1) {
+			i := g.index(r, c)
+			changes = append(changes, change{d, i, g.jump[d][i]})
+			g.jump[d][i] = stop
+		}
+	}
+	i := g.index(row, col)
+	wasBlocked := g.blocked[i]
+	g.blocked[i] = true
+	return func() {
+		g.blocked[i] = wasBlocked
+		for _, c := range changes {
+			g.jump[c.d][c.i] = c.old
+		}
+	}
+}
+
+// loops reports whether a guard starting at cell start facing d patrols
+// forever. It takes time proportional to the number of turns made.
+func (g *grid) loops(start, d int) bool {
+	g.generation++
+	if g.generation == 0 {
+		// The counter wrapped, so old marks could be mistaken for new ones.
+		for d := range g.seen {
+			g.seen[d] = make([]uint32, len(g.seen[d]))
+		}
+		g.generation = 1
+	}
+	i := int32(start)
+	for {
+		i = g.jump[d][i]
+		if i == -1 {
+			return false
+		}
+		d = (d + 1) % 4
+		if g.seen[d][i] == g.generation {
+			return true
+		}
+		g.seen[d][i] = g.generation
+	}
+}
+
+// guardResult is how one of several guards patrolling together fared.
+type guardResult struct {
+	positions int
+	outcome   outcome
+}
+
+// simulateGuards moves every guard one step or turn per tick, in lockstep.
+// A guard treats cells occupied by another guard at the start of the tick as
+// obstructions, and when several guards would step into the same cell none of
+// them moves and they all turn instead. Once every remaining guard is back in
+// a position and direction they were all in together before, they loop.
+func simulateGuards(m [][]string, guards []guard) []guardResult {
+	type state struct {
+		position
+		direction string
+	}
+	states := make([]state, len(guards))
+	visited := make([]map[position]bool, len(guards))
+	active := make([]bool, len(guards))
+	results := make([]guardResult, len(guards))
+	for i, g := range guards {
+		states[i] = state{position{g.row, g.col}, g.direction}
+		visited[i] = map[position]bool{states[i].position: true}
+		active[i] = true
+		results[i].outcome = exited
+	}
+
+	seen := make(map[string]bool)
+	for slices.Contains(active, true) {
+		var key strings.Builder
+		for i, st := range states {
+			if active[i] {
+				fmt.Fprintf(&key, "%d,%d,%s;", st.row, st.col, st.direction)
+			} else {
+				key.WriteString("-;")
+			}
+		}
+		if seen[key.String()] {
+			for i := range guards {
+				if active[i] {
+					results[i].outcome = looped
+				}
+			}
+			break
+		}
+		seen[key.String()] = true
+
+		occupied := make(map[position]bool)
+		for i, st := range states {
+			if active[i] {
+				occupied[st.position] = true
+			}
+		}
+		targets := make(map[position]int)
+		next := make([]*position, len(guards))
+		for i, st := range states {
+			if !active[i] {
 				continue
 			}
-			if col == "." {
-				set = true
-				m[i][j] = "#"
+			nextRow, nextCol := nextPos(st.direction, st.row, st.col)
+			if nextRow < 0 || nextRow >= len(m) || nextCol < 0 || nextCol >= len(m[nextRow]) {
+				active[i] = false
+				continue
 			}
-			tmpM := deepCopyMap(m)
-			result := traverse(tmpM, startingCol, startingRow, traversals, i, j)
-			traversals++
-			if result == -1 {
-				blocks++
+			p := position{nextRow, nextCol}
+			if m[nextRow][nextCol] == "#" || occupied[p] {
+				continue
 			}
-			if set {
-				m[i][j] = "."
+			next[i] = &p
+			targets[p]++
+		}
+		for i, p := range next {
+			if !active[i] {
+				continue
 			}
-			if *verbose {
-				time.Sleep(250 * time.Millisecond)
+			if p == nil || targets[*p] > 1 {
+				states[i].direction = turn(states[i].direction)
+				continue
 			}
+			states[i].position = *p
+			visited[i][*p] = true
 		}
 	}
 
-	if *verbose {
-		fmt.Printf("Traversals: %d\n", traversals)
+	for i := range results {
+		results[i].positions = len(visited[i])
 	}
+	return results
+}
+
+// frameWriter encodes the frames of a recording.
+type frameWriter interface {
+	writeFrame(m [][]string) error
+	close() error
+}
+
+// recorder captures the map after every step of a patrol, keeping one frame
+// in every skip. The first error is kept and returned by close. A nil
+// recorder records nothing.
+type recorder struct {
+	w      frameWriter
+	skip   int
+	steps  int
+	err    error
+	closer io.Closer
+}
 
-	return blocks
+func newRecorder(w frameWriter, skip int) *recorder {
+	return &recorder{
+		w:    w,
+		skip: max(skip, 1),
+	}
+}
+
+// newFileRecorder records to a file, choosing the format from its extension.
+func newFileRecorder(path string, m [][]string, skip int) (*recorder, error) {
+	var newWriter func(io.Writer, [][]string) frameWriter
+	switch filepath.Ext(path) {
+	case ".cast":
+		newWriter = newAsciicastWriter
+	case ".gif":
+		newWriter = newGIFWriter
+	default:
+		return nil, fmt.Errorf("unknown recording format: %s", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rec := newRecorder(newWriter(f, m), skip)
+	rec.closer = f
+	return rec, nil
+}
+
+func (r *recorder) capture(m [][]string) {
+	if r == nil || r.err != nil {
+		return
+	}
+	if r.steps%r.skip == 0 {
+		r.err = r.w.writeFrame(m)
+	}
+	r.steps++
+}
+
+// finish records the final state of the map, whether or not it falls on a
+// skipped step.
+func (r *recorder) finish(m [][]string) {
+	if r == nil || r.err != nil {
+		return
+	}
+	r.err = r.w.writeFrame(m)
+}
+
+func (r *recorder) close() error {
+	if r == nil {
+		return nil
+	}
+	err := r.err
+	if cerr := r.w.close(); err == nil {
+		err = cerr
+	}
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+const frameInterval = 0.05
+
+// asciicastWriter streams frames as an asciinema v2 recording.
+type asciicastWriter struct {
+	w      *bufio.Writer
+	frames int
+}
+
+func newAsciicastWriter(w io.Writer, m [][]string) frameWriter {
+	a := &asciicastWriter{w: bufio.NewWriter(w)}
+	width := 0
+	for _, row := range m {
+		width = max(width, len(row))
+	}
+	fmt.Fprintf(a.w, "{\"version\": 2, \"width\": %d, \"height\": %d}\n", width, len(m))
+	return a
+}
+
+func (a *asciicastWriter) writeFrame(m [][]string) error {
+	var sb strings.Builder
+	if a.frames == 0 {
+		sb.WriteString("\033[2J")
+	}
+	sb.WriteString("\033[H")
+	for i, row := range m {
+		if i > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString(strings.Join(row, ""))
+	}
+	event, err := json.Marshal([]any{float64(a.frames) * frameInterval, "o", sb.String()})
+	if err != nil {
+		return err
+	}
+	a.frames++
+	a.w.Write(event)
+	_, err = a.w.WriteString("\n")
+	return err
+}
+
+func (a *asciicastWriter) close() error {
+	return a.w.Flush()
+}
+
+// gifCellSize is the width and height in pixels of a map cell in a GIF.
+const gifCellSize = 4
+
+var (
+	gifPalette = color.Palette{
+		color.RGBA{0x0f, 0x0f, 0x23, 0xff}, // empty
+		color.RGBA{0xcc, 0xcc, 0xcc, 0xff}, // obstruction
+		color.RGBA{0x00, 0x99, 0x00, 0xff}, // visited
+		color.RGBA{0xff, 0xff, 0x66, 0xff}, // guard
+		color.RGBA{0xff, 0x00, 0x00, 0xff}, // new obstruction
+	}
+	gifCellColors = map[string]uint8{
+		"#": 1,
+		"X": 2,
+		"^": 3,
+		">": 3,
+		"v": 3,
+		"<": 3,
+		"O": 4,
+	}
+)
+
+// gifWriter collects frames for an animated GIF, which is written on close as
+// image/gif can only encode a whole animation at once. Only the first frame
+// covers the whole map; later ones cover just the cells that changed and are
+// drawn over the frames before them, which keeps long patrols small.
+type gifWriter struct {
+	w    io.Writer
+	anim gif.GIF
+	// cells holds the palette index of every cell as of the last frame.
+	cells [][]uint8
+}
+
+func newGIFWriter(w io.Writer, m [][]string) frameWriter {
+	return &gifWriter{w: w}
+}
+
+func (g *gifWriter) writeFrame(m [][]string) error {
+	width := 0
+	for _, row := range m {
+		width = max(width, len(row))
+	}
+	cells := make([][]uint8, len(m))
+	for i, row := range m {
+		cells[i] = make([]uint8, width)
+		for j, cell := range row {
+			cells[i][j] = gifCellColors[cell]
+		}
+	}
+
+	// changed is in cells rather than pixels.
+	changed := image.Rect(0, 0, width, len(m))
+	if g.cells != nil {
+		changed = image.Rectangle{}
+		for i, row := range cells {
+			for j, c := range row {
+				if c != g.cells[i][j] {
+					changed = changed.Union(image.Rect(j, i, j+1, i+1))
+				}
+			}
+		}
+		if changed.Empty() {
+			// Every capture gets a frame, so redraw a single cell.
+			changed = image.Rect(0, 0, 1, 1)
+		}
+	}
+	g.cells = cells
+
+	img := image.NewPaletted(image.Rectangle{changed.Min.Mul(gifCellSize), changed.Max.Mul(gifCellSize)}, gifPalette)
+	for i := changed.Min.Y; i < changed.Max.Y; i++ {
+		for j := changed.Min.X; j < changed.Max.X; j++ {
+			c := cells[i][j]
+			if c == 0 {
+				continue
+			}
+			for y := i * gifCellSize; y < (i+1)*gifCellSize; y++ {
+				for x := j * gifCellSize; x < (j+1)*gifCellSize; x++ {
+					img.SetColorIndex(x, y, c)
+				}
+			}
+		}
+	}
+	g.anim.Image = append(g.anim.Image, img)
+	g.anim.Delay = append(g.anim.Delay, int(frameInterval*100))
+	g.anim.Disposal = append(g.anim.Disposal, gif.DisposalNone)
+	return nil
+}
+
+func (g *gifWriter) close() error {
+	if len(g.anim.Image) == 0 {
+		return nil
+	}
+	return gif.EncodeAll(g.w, &g.anim)
+}
+
+// stepper is the state of the interactive mode: a patrol that can be stepped
+// through, with obstructions added or removed along the way.
+type stepper struct {
+	original [][]string
+	m        [][]string
+	start    guard
+
+	path      []guard
+	loopStart int
+	outcome   outcome
+
+	step   int
+	cursor position
+	// count is the number typed before a command, or 0 if none was.
+	count   int
+	message string
+}
+
+func newStepper(m [][]string, start guard) *stepper {
+	s := &stepper{
+		original: m,
+		m:        deepCopyMap(m),
+		start:    start,
+		cursor:   position{start.row, start.col},
+	}
+	s.simulate()
+	return s
+}
+
+func (s *stepper) simulate() {
+	s.path, s.loopStart, s.outcome = patrol(s.m, s.start)
+	s.step = min(s.step, len(s.path)-1)
+}
+
+// toggleObstruction places an obstruction at the cursor, or removes the one
+// already there, and replays the patrol.
+func (s *stepper) toggleObstruction() {
+	p := s.cursor
+	if p.row == s.start.row && p.col == s.start.col {
+		s.message = "The guard's starting position cannot be obstructed"
+		return
+	}
+	if s.m[p.row][p.col] == "#" {
+		s.m[p.row][p.col] = "."
+	} else {
+		s.m[p.row][p.col] = "#"
+	}
+	s.simulate()
+}
+
+func (s *stepper) moveTo(step int) {
+	s.step = max(0, min(step, len(s.path)-1))
+}
+
+func (s *stepper) moveCursor(direction string) {
+	row, col := nextPos(direction, s.cursor.row, s.cursor.col)
+	if row >= 0 && row < len(s.m) && col >= 0 && col < len(s.m[row]) {
+		s.cursor = position{row, col}
+	}
+}
+
+// handleKey applies a key to the stepper and reports whether to quit.
+func (s *stepper) handleKey(key string) bool {
+	if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
+		s.count = s.count*10 + int(key[0]-'0')
+		return false
+	}
+	count := s.count
+	s.count = 0
+	s.message = ""
+	switch key {
+	case "n", " ":
+		s.moveTo(s.step + max(count, 1))
+	case "p", "b":
+		s.moveTo(s.step - max(count, 1))
+	case "g":
+		s.moveTo(count)
+	case "G":
+		s.moveTo(len(s.path) - 1)
+	case "k", "up":
+		s.moveCursor("up")
+	case "j", "down":
+		s.moveCursor("down")
+	case "h", "left":
+		s.moveCursor("left")
+	case "l", "right":
+		s.moveCursor("right")
+	case "o":
+		s.toggleObstruction()
+	case "q", "\x03":
+		return true
+	default:
+		s.message = fmt.Sprintf("Unknown key %q", key)
+	}
+	return false
+}
+
+const stepperHelp = "n/space: step  p: step back  <count>n/p: several steps  <count>g: go to step  G: last step\r\n" +
+	"hjkl/arrows: move cursor  o: add/remove obstruction  q: quit\r\n"
+
+// render draws the map as it is after the current step, marking the visited
+// cells with X, added obstructions with O and the cursor in reverse video.
+func (s *stepper) render(w io.Writer) error {
+	m := deepCopyMap(s.m)
+	for _, g := range s.path[:s.step] {
+		m[g.row][g.col] = "X"
+	}
+	for i, row := range m {
+		for j := range row {
+			if s.m[i][j] == "#" && s.original[i][j] != "#" {
+				m[i][j] = "O"
+			}
+		}
+	}
+	curr := s.path[s.step]
+	m[curr.row][curr.col] = charForDirection(curr.direction)
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H\x1b[2J")
+	for i, row := range m {
+		for j, cell := range row {
+			if i == s.cursor.row && j == s.cursor.col {
+				sb.WriteString("\x1b[7m" + cell + "\x1b[0m")
+			} else {
+				sb.WriteString(cell)
+			}
+		}
+		sb.WriteString("\r\n")
+	}
+	fmt.Fprintf(&sb, "Step %d/%d, guard at %d, %d facing %s, cursor at %d, %d\r\n", s.step, len(s.path)-1, curr.row, curr.col, curr.direction, s.cursor.row, s.cursor.col)
+	if s.outcome == looped {
+		fmt.Fprintf(&sb, "The path loops: the loop is entered at step %d and is %d steps long\r\n", s.loopStart, len(s.path)-s.loopStart)
+	} else {
+		fmt.Fprintf(&sb, "The path leaves the map after step %d\r\n", len(s.path)-1)
+	}
+	if s.message != "" {
+		sb.WriteString(s.message + "\r\n")
+	}
+	sb.WriteString(stepperHelp)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// readKey reads a key press, naming arrow keys by their direction. Line
+// endings are skipped so commands can also be typed a line at a time. A
+// terminal sends an escape sequence all at once, so an Esc with nothing
+// buffered after it is returned as a key of its own rather than waiting for
+// the next key press.
+func readKey(r *bufio.Reader) (string, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '\r', '\n':
+			continue
+		case '\x1b':
+			if r.Buffered() < 2 {
+				break
+			}
+			if next, err := r.Peek(2); err == nil && next[0] == '[' {
+				r.Discard(2)
+				switch next[1] {
+				case 'A':
+					return "up", nil
+				case 'B':
+					return "down", nil
+				case 'C':
+					return "right", nil
+				case 'D':
+					return "left", nil
+				}
+			}
+		}
+		return string(b), nil
+	}
+}
+
+// runStepper redraws the stepper after every key read from in until the quit
+// key or the end of the input.
+func runStepper(s *stepper, in io.Reader, out io.Writer) error {
+	r := bufio.NewReader(in)
+	for {
+		if err := s.render(out); err != nil {
+			return err
+		}
+		key, err := readKey(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if s.handleKey(key) {
+			return nil
+		}
+	}
 }
 
 func deepCopyMap(m [][]string) [][]string {
@@ -1,6 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -46,11 +56,10 @@ func TestTurn(t *testing.T) {
 }
 func TestParseMap(t *testing.T) {
 	tests := []struct {
-		input       string
-		expectedMap [][]string
-		expectedCol int
-		expectedRow int
-		expectedErr bool
+		input          string
+		expectedMap    [][]string
+		expectedGuards []guard
+		expectedErr    bool
 	}{
 		{
 			input: "...\n.^.\n...",
@@ -59,9 +68,8 @@ func TestParseMap(t *testing.T) {
 				{".", "^", "."},
 				{".", ".", "."},
 			},
-			expectedCol: 1,
-			expectedRow: 1,
-			expectedErr: false,
+			expectedGuards: []guard{{row: 1, col: 1, direction: "up"}},
+			expectedErr:    false,
 		},
 		{
 			input: "###\n#^#\n###",
@@ -70,9 +78,8 @@ func TestParseMap(t *testing.T) {
 				{"#", "^", "#"},
 				{"#", "#", "#"},
 			},
-			expectedCol: 1,
-			expectedRow: 1,
-			expectedErr: false,
+			expectedGuards: []guard{{row: 1, col: 1, direction: "up"}},
+			expectedErr:    false,
 		},
 		{
 			input: "^..\n...\n...",
@@ -81,20 +88,37 @@ func TestParseMap(t *testing.T) {
 				{".", ".", "."},
 				{".", ".", "."},
 			},
-			expectedCol: 0,
-			expectedRow: 0,
+			expectedGuards: []guard{{row: 0, col: 0, direction: "up"}},
+			expectedErr:    false,
+		},
+		{
+			input: "..>\nv..\n.<.",
+			expectedMap: [][]string{
+				{".", ".", ">"},
+				{"v", ".", "."},
+				{".", "<", "."},
+			},
+			expectedGuards: []guard{
+				{row: 0, col: 2, direction: "right"},
+				{row: 1, col: 0, direction: "down"},
+				{row: 2, col: 1, direction: "left"},
+			},
 			expectedErr: false,
 		},
+		{
+			input:       "...\n.#.\n...",
+			expectedErr: true,
+		},
 	}
 
 	for _, test := range tests {
-		m, col, row, err := parseMap(test.input)
+		m, guards, err := parseMap(test.input)
 		if (err != nil) != test.expectedErr {
 			t.Errorf("parseMap(%q) error = %v, expectedErr %v", test.input, err, test.expectedErr)
 			continue
 		}
-		if !compareMaps(m, test.expectedMap) || col != test.expectedCol || row != test.expectedRow {
-			t.Errorf("parseMap(%q) = (%v, %d, %d), want (%v, %d, %d)", test.input, m, col, row, test.expectedMap, test.expectedCol, test.expectedRow)
+		if !compareMaps(m, test.expectedMap) || !reflect.DeepEqual(guards, test.expectedGuards) {
+			t.Errorf("parseMap(%q) = (%v, %v), want (%v, %v)", test.input, m, guards, test.expectedMap, test.expectedGuards)
 		}
 	}
 }
@@ -116,44 +140,637 @@ func compareMaps(a, b [][]string) bool {
 	return true
 }
 func TestTraverse(t *testing.T) {
+	// A long dead end: the guard walks up a corridor, turns around at the top
+	// and walks back down over more than 100 visited cells before leaving.
+	corridor := "#.\n.#\n" + strings.Repeat("..\n", 148) + "^."
+
 	tests := []struct {
-		input       string
-		startingCol int
-		startingRow int
-		expected    int
+		name            string
+		input           string
+		expected        int
+		expectedOutcome outcome
 	}{
 		{
-			input:       "...\n.^.\n...",
-			startingCol: 1,
-			startingRow: 1,
-			expected:    2,
+			name:            "Exit straight up",
+			input:           "...\n.^.\n...",
+			expected:        2,
+			expectedOutcome: exited,
+		},
+		{
+			name:            "Exit immediately",
+			input:           "^..\n...\n...",
+			expected:        1,
+			expectedOutcome: exited,
 		},
 		{
-			input:       "^..\n...\n...",
-			startingCol: 0,
-			startingRow: 0,
-			expected:    1,
+			name:            "Turn twice",
+			input:           ".#.\n.^#\n...",
+			expected:        2,
+			expectedOutcome: exited,
 		},
 		{
-			input:       ".#.\n.^#\n...",
-			startingCol: 1,
-			startingRow: 1,
-			expected:    2,
+			name:            "Small loop",
+			input:           ".#..\n...#\n#^..\n..#.",
+			expected:        4,
+			expectedOutcome: looped,
+		},
+		{
+			name:            "Boxed in",
+			input:           ".#.\n#^#\n.#.",
+			expected:        1,
+			expectedOutcome: looped,
+		},
+		{
+			name:            "Start facing right",
+			input:           "...\n.>#\n...",
+			expected:        2,
+			expectedOutcome: exited,
+		},
+		{
+			name:            "Start facing down",
+			input:           "#..\nv..\n...",
+			expected:        2,
+			expectedOutcome: exited,
+		},
+		{
+			name:            "Start facing left into a loop",
+			input:           ".#..\n...#\n#.<.\n..#.",
+			expected:        4,
+			expectedOutcome: looped,
+		},
+		{
+			name:            "Long dead end",
+			input:           corridor,
+			expected:        150,
+			expectedOutcome: exited,
 		},
 	}
 
 	for _, test := range tests {
-		m, _, _, err := parseMap(test.input)
+		m, guards, err := parseMap(test.input)
 		if err != nil {
 			t.Fatalf("parseMap(%q) error = %v", test.input, err)
 		}
-		result := traverse(m, test.startingCol, test.startingRow, 0, 0, 0)
-		if result != test.expected {
-			t.Errorf("traverse(%q, %d, %d) = %d; want %d", test.input, test.startingCol, test.startingRow, result, test.expected)
+		positions, result := traverse(m, guards[0], nil)
+		if positions != test.expected || result != test.expectedOutcome {
+			t.Errorf("traverse(%s) = %d, %v; want %d, %v", test.name, positions, result, test.expected, test.expectedOutcome)
+		}
+	}
+}
+
+func TestSimulateGuards(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []guardResult
+	}{
+		{
+			name:  "Same target cell",
+			input: ">.<",
+			expected: []guardResult{
+				{positions: 1, outcome: exited},
+				{positions: 1, outcome: exited},
+			},
+		},
+		{
+			name:  "Blocked by another guard",
+			input: "v.\n^.",
+			expected: []guardResult{
+				{positions: 1, outcome: exited},
+				{positions: 2, outcome: exited},
+			},
+		},
+		{
+			name:  "Separate loops",
+			input: ".#...#..\n...#...#\n#^..#^..\n..#...#.",
+			expected: []guardResult{
+				{positions: 4, outcome: looped},
+				{positions: 4, outcome: looped},
+			},
+		},
+		{
+			name:  "One loops, one leaves",
+			input: ".#......\n...#....\n#^..#.>.\n..#.....",
+			expected: []guardResult{
+				{positions: 4, outcome: looped},
+				{positions: 2, outcome: exited},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		m, guards, err := parseMap(test.input)
+		if err != nil {
+			t.Fatalf("parseMap(%q) error = %v", test.input, err)
+		}
+		result := simulateGuards(m, guards)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("simulateGuards(%s) = %v; want %v", test.name, result, test.expected)
+		}
+	}
+
+	// A lone guard must patrol exactly as traverse does.
+	r := rand.New(rand.NewSource(5))
+	for range 100 {
+		m, start := randomMap(r, 15, 15)
+		positions, result := traverse(m, start, nil)
+		expected := []guardResult{{positions: positions, outcome: result}}
+		if got := simulateGuards(m, []guard{start}); !reflect.DeepEqual(got, expected) {
+			t.Errorf("simulateGuards() = %v; want %v\n%s", got, expected, formatMap(m))
+		}
+	}
+}
+
+func TestCheckSingleGuardFlags(t *testing.T) {
+	defer func(r string, i bool, o string) {
+		*record, *interactive, *listObstructions = r, i, o
+	}(*record, *interactive, *listObstructions)
+	reset := func() {
+		*record, *interactive, *listObstructions = "", false, ""
+	}
+	tests := []struct {
+		name        string
+		set         func()
+		guards      int
+		expectError bool
+	}{
+		{"no flags", func() {}, 2, false},
+		{"record with one guard", func() { *record = "patrol.gif" }, 1, false},
+		{"record with several guards", func() { *record = "patrol.gif" }, 2, true},
+		{"interactive with one guard", func() { *interactive = true }, 1, false},
+		{"interactive with several guards", func() { *interactive = true }, 3, true},
+		{"obstructions with one guard", func() { *listObstructions = "map" }, 1, false},
+		{"obstructions with several guards", func() { *listObstructions = "list" }, 2, true},
+	}
+
+	for _, test := range tests {
+		reset()
+		test.set()
+		if err := checkSingleGuardFlags(test.guards); (err != nil) != test.expectError {
+			t.Errorf("%s: checkSingleGuardFlags(%d) = %v; want error %v", test.name, test.guards, err, test.expectError)
+		}
+	}
+}
+func TestRecordAsciicast(t *testing.T) {
+	m, guards, err := parseMap(".#.\n...\n.^.")
+	if err != nil {
+		t.Fatalf("parseMap() error = %v", err)
+	}
+	var buf bytes.Buffer
+	rec := newRecorder(newAsciicastWriter(&buf, m), 1)
+	traverse(m, guards[0], rec)
+	if err := rec.close(); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+
+	expected := `{"version": 2, "width": 3, "height": 3}
+[0,"o","\u001b[2J\u001b[H.#.\r\n...\r\n.^."]
+[0.05,"o","\u001b[H.#.\r\n.^.\r\n.X."]
+[0.1,"o","\u001b[H.#.\r\n.\u003e.\r\n.X."]
+[0.15000000000000002,"o","\u001b[H.#.\r\n.X\u003e\r\n.X."]
+[0.2,"o","\u001b[H.#.\r\n.XX\r\n.X."]
+`
+	if buf.String() != expected {
+		t.Errorf("recording = %q; want %q", buf.String(), expected)
+	}
+}
+
+func TestRecordGIF(t *testing.T) {
+	m, guards, err := parseMap(demoMap)
+	if err != nil {
+		t.Fatalf("parseMap() error = %v", err)
+	}
+	for _, test := range []struct {
+		skip     int
+		expected int
+	}{
+		{1, 56},
+		{10, 7},
+		{1000, 2},
+	} {
+		var buf bytes.Buffer
+		rec := newRecorder(newGIFWriter(&buf, m), test.skip)
+		traverse(m, guards[0], rec)
+		if err := rec.close(); err != nil {
+			t.Fatalf("close() error = %v", err)
+		}
+		anim, err := gif.DecodeAll(&buf)
+		if err != nil {
+			t.Fatalf("gif.DecodeAll() error = %v", err)
+		}
+		if len(anim.Image) != test.expected {
+			t.Errorf("frames with skip %d = %d; want %d", test.skip, len(anim.Image), test.expected)
+		}
+		full := anim.Image[0].Bounds()
+		if full.Dx() != 10*gifCellSize || full.Dy() != 10*gifCellSize {
+			t.Errorf("frame bounds = %v; want %dx%d", full, 10*gifCellSize, 10*gifCellSize)
+		}
+
+		// Later frames cover only the cells that changed, at most the two
+		// the guard moved between when every step is recorded.
+		screen := image.NewPaletted(full, gifPalette)
+		for i, frame := range anim.Image {
+			bounds := frame.Bounds()
+			if i > 0 {
+				if !bounds.In(full) || bounds.Min.X%gifCellSize != 0 || bounds.Min.Y%gifCellSize != 0 {
+					t.Errorf("frame %d with skip %d has bounds %v; want whole cells within %v", i, test.skip, bounds, full)
+				}
+				if cells := bounds.Dx() * bounds.Dy() / (gifCellSize * gifCellSize); test.skip == 1 && cells > 2 {
+					t.Errorf("frame %d with skip 1 covers %d cells; want at most 2", i, cells)
+				}
+			}
+			if anim.Disposal[i] != gif.DisposalNone {
+				t.Errorf("frame %d disposal = %d; want %d", i, anim.Disposal[i], gif.DisposalNone)
+			}
+			draw.Draw(screen, bounds, frame, bounds.Min, draw.Src)
+		}
+		visited := 0
+		for _, c := range screen.Pix {
+			if c == gifCellColors["X"] {
+				visited++
+			}
+		}
+		if visited != 41*gifCellSize*gifCellSize {
+			t.Errorf("visited pixels with skip %d = %d; want %d", test.skip, visited, 41*gifCellSize*gifCellSize)
 		}
 	}
 }
 
+func TestPatrol(t *testing.T) {
+	tests := []struct {
+		name              string
+		input             string
+		expectedSteps     int
+		expectedLoopStart int
+		expectedOutcome   outcome
+	}{
+		{
+			name:              "demo",
+			input:             demoMap,
+			expectedSteps:     55,
+			expectedLoopStart: -1,
+			expectedOutcome:   exited,
+		},
+		{
+			name:              "turns count as steps",
+			input:             ".#.\n...\n.^.",
+			expectedSteps:     4,
+			expectedLoopStart: -1,
+			expectedOutcome:   exited,
+		},
+		{
+			name:              "loop entered after start",
+			input:             ".#..\n...#\n#...\n..#.\n.^..",
+			expectedSteps:     10,
+			expectedLoopStart: 2,
+			expectedOutcome:   looped,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, guards, err := parseMap(test.input)
+			if err != nil {
+				t.Fatalf("parseMap() error = %v", err)
+			}
+			path, loopStart, result := patrol(m, guards[0])
+			if len(path) != test.expectedSteps || loopStart != test.expectedLoopStart || result != test.expectedOutcome {
+				t.Errorf("patrol() = %d steps, loop start %d, %v; want %d steps, loop start %d, %v", len(path), loopStart, result, test.expectedSteps, test.expectedLoopStart, test.expectedOutcome)
+			}
+			if path[0] != guards[0] {
+				t.Errorf("patrol() starts at %v; want %v", path[0], guards[0])
+			}
+		})
+	}
+}
+
+func TestStepper(t *testing.T) {
+	m, guards, err := parseMap(demoMap)
+	if err != nil {
+		t.Fatalf("parseMap() error = %v", err)
+	}
+	s := newStepper(m, guards[0])
+	steps := []struct {
+		keys            string
+		expectedStep    int
+		expectedCursor  position
+		expectedOutcome outcome
+	}{
+		{"n", 1, position{6, 4}, exited},
+		{"10n", 11, position{6, 4}, exited},
+		{"3p", 8, position{6, 4}, exited},
+		{"100n", 54, position{6, 4}, exited},
+		{"g", 0, position{6, 4}, exited},
+		{"G", 54, position{6, 4}, exited},
+		{"hhl", 54, position{6, 3}, exited},
+		// Blocking 6, 3 is one of the demo's loop-causing obstructions, and
+		// the loop is shorter than the original patrol.
+		{"o", 21, position{6, 3}, looped},
+		{"o", 21, position{6, 3}, exited},
+		{"lo", 21, position{6, 4}, exited},
+	}
+	for _, step := range steps {
+		for _, key := range step.keys {
+			if s.handleKey(string(key)) {
+				t.Fatalf("handleKey(%q) quit", key)
+			}
+		}
+		if s.step != step.expectedStep || s.cursor != step.expectedCursor || s.outcome != step.expectedOutcome {
+			t.Errorf("after %q: step %d, cursor %v, %v; want step %d, cursor %v, %v", step.keys, s.step, s.cursor, s.outcome, step.expectedStep, step.expectedCursor, step.expectedOutcome)
+		}
+	}
+	if s.message == "" {
+		t.Error("obstructing the starting position gave no message")
+	}
+	if !s.handleKey("q") {
+		t.Error("handleKey(\"q\") did not quit")
+	}
+}
+
+// keyPresses delivers one key press per read, as a terminal in raw mode does,
+// and notes any read made once they run out.
+type keyPresses struct {
+	presses []string
+	waited  bool
+}
+
+func (k *keyPresses) Read(p []byte) (int, error) {
+	if len(k.presses) == 0 {
+		k.waited = true
+		return 0, io.EOF
+	}
+	n := copy(p, k.presses[0])
+	k.presses = k.presses[1:]
+	return n, nil
+}
+
+func TestReadKey(t *testing.T) {
+	tests := []struct {
+		presses  []string
+		expected []string
+	}{
+		{[]string{"\x1b[A", "\x1b[B", "\x1b[C", "\x1b[D"}, []string{"up", "down", "right", "left"}},
+		{[]string{"\x1b"}, []string{"\x1b"}},
+		{[]string{"\x1b", "q"}, []string{"\x1b", "q"}},
+		{[]string{"\x1b[Z"}, []string{"\x1b"}},
+		{[]string{"2n\n", "\r", "p"}, []string{"2", "n", "p"}},
+	}
+
+	for _, test := range tests {
+		in := &keyPresses{presses: test.presses}
+		r := bufio.NewReader(in)
+		var keys []string
+		for range test.expected {
+			key, err := readKey(r)
+			if err != nil {
+				t.Fatalf("readKey(%q) error = %v", test.presses, err)
+			}
+			keys = append(keys, key)
+		}
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("readKey(%q) = %q; want %q", test.presses, keys, test.expected)
+		}
+		if in.waited {
+			t.Errorf("readKey(%q) waited for another key press", test.presses)
+		}
+	}
+}
+
+func TestRunStepper(t *testing.T) {
+	m, guards, err := parseMap(".#.\n...\n.^.")
+	if err != nil {
+		t.Fatalf("parseMap() error = %v", err)
+	}
+	var out strings.Builder
+	// Arrow keys arrive as escape sequences; the final key is never read.
+	if err := runStepper(newStepper(m, guards[0]), strings.NewReader("nk\x1b[Do2n\nqn"), &out); err != nil {
+		t.Fatalf("runStepper() error = %v", err)
+	}
+	// The map is drawn once at the start and once after every key but the
+	// last one read.
+	frames := strings.Split(out.String(), "\x1b[H\x1b[2J")[1:]
+	if len(frames) != 7 {
+		t.Errorf("frames = %d; want 7", len(frames))
+	}
+	expected := ".#.\r\n\x1b[7mO\x1b[0mX>\r\n.X.\r\n" +
+		"Step 3/3, guard at 1, 2 facing right, cursor at 1, 0\r\n" +
+		"The path leaves the map after step 3\r\n" +
+		stepperHelp
+	if last := frames[len(frames)-1]; last != expected {
+		t.Errorf("last frame = %q; want %q", last, expected)
+	}
+}
+
+func TestPart2(t *testing.T) {
+	m, guards, err := parseMap(demoMap)
+	if err != nil {
+		t.Fatalf("parseMap() error = %v", err)
+	}
+	for _, workers := range []int{1, 2, 8} {
+		if result, err := part2(m, guards[0], workers); err != nil || result != 6 {
+			t.Errorf("part2(%d workers) = %d, %v; want 6", workers, result, err)
+		}
+	}
+}
+
+func TestPart2AlreadyLooping(t *testing.T) {
+	m, guards, err := parseMap(".#..\n...#\n#...\n..#.\n.^..")
+	if err != nil {
+		t.Fatalf("parseMap() error = %v", err)
+	}
+	if result, err := part2(m, guards[0], 2); err != errAlreadyLoops {
+		t.Errorf("part2() = %d, %v; want error %v", result, err, errAlreadyLoops)
+	}
+}
+
+func TestWriteObstructions(t *testing.T) {
+	m, guards, err := parseMap(demoMap)
+	if err != nil {
+		t.Fatalf("parseMap() error = %v", err)
+	}
+	obstructions, err := loopObstructions(m, guards[0], 2)
+	if err != nil {
+		t.Fatalf("loopObstructions() error = %v", err)
+	}
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: "list",
+			expected: `6,3: loop of 22 steps entered at step 0
+7,6: loop of 16 steps entered at step 19
+7,7: loop of 16 steps entered at step 35
+8,1: loop of 20 steps entered at step 24
+8,3: loop of 42 steps entered at step 0
+9,7: loop of 18 steps entered at step 37
+`,
+		},
+		{
+			format: "map",
+			expected: `....#.....
+.........#
+..........
+..#.......
+.......#..
+..........
+.#.O^.....
+......OO#.
+#O.O......
+......#O..
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var sb strings.Builder
+			if err := writeObstructions(&sb, test.format, m, guards[0], obstructions); err != nil {
+				t.Fatalf("writeObstructions() error = %v", err)
+			}
+			if sb.String() != test.expected {
+				t.Errorf("writeObstructions() =\n%s\nwant\n%s", sb.String(), test.expected)
+			}
+		})
+	}
+
+	if err := writeObstructions(io.Discard, "json", m, guards[0], obstructions); err == nil {
+		t.Error("writeObstructions() with an unknown format succeeded")
+	}
+}
+
+func TestPart2MatchesExhaustiveSearch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for range 20 {
+		m, start := randomMap(r, 20, 20)
+		if _, result := traverse(m, start, nil); result == looped {
+			continue
+		}
+
+		expected := 0
+		for i, row := range m {
+			for j := range row {
+				if m[i][j] != "." {
+					continue
+				}
+				m[i][j] = "#"
+				if _, result := traverse(m, start, nil); result == looped {
+					expected++
+				}
+				m[i][j] = "."
+			}
+		}
+
+		for _, workers := range []int{1, 4} {
+			if result, err := part2(m, start, workers); err != nil || result != expected {
+				t.Errorf("part2(%d workers) = %d, %v; want %d\n%s", workers, result, err, expected, formatMap(m))
+			}
+		}
+	}
+}
+
+func TestGridJumps(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for range 20 {
+		m, _ := randomMap(r, 12, 17)
+		g := newGrid(m)
+		checkJumps(t, g, m)
+
+		// Adding an obstruction must give the same jumps as rebuilding the
+		// grid, and undoing it must restore the original ones.
+		row, col := r.Intn(12), r.Intn(17)
+		original := m[row][col]
+		undo := g.addObstruction(row, col)
+		m[row][col] = "#"
+		checkJumps(t, g, m)
+		undo()
+		m[row][col] = original
+		checkJumps(t, g, m)
+	}
+}
+
+// checkJumps compares the grid's jump tables with walking the map step by step.
+func checkJumps(t *testing.T, g *grid, m [][]string) {
+	t.Helper()
+	for i, row := range m {
+		for j, cell := range row {
+			if cell == "#" {
+				continue
+			}
+			for d := range 4 {
+				expected := -1
+				r, c := i, j
+				for {
+					nr, nc := step(d, r, c, 1)
+					if nr < 0 || nr >= len(m) || nc < 0 || nc >= len(m[nr]) {
+						break
+					}
+					if m[nr][nc] == "#" {
+						expected = g.index(r, c)
+						break
+					}
+					r, c = nr, nc
+				}
+				if result := int(g.jump[d][g.index(i, j)]); result != expected {
+					t.Fatalf("jump[%d][%d, %d] = %d; want %d\n%s", d, i, j, result, expected, formatMap(m))
+				}
+			}
+		}
+	}
+}
+
+func TestGridLoops(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for range 200 {
+		m, start := randomMap(r, 15, 15)
+		g := newGrid(m)
+		_, result := traverse(m, start, nil)
+		if loops := g.loops(g.index(start.row, start.col), directionIndex[start.direction]); loops != (result == looped) {
+			t.Errorf("loops() = %v; traverse() = %v\n%s", loops, result, formatMap(m))
+		}
+	}
+}
+
+// randomMap returns a map with roughly one obstruction in eight cells and a
+// guard facing any direction somewhere in the middle.
+func randomMap(r *rand.Rand, rows, cols int) ([][]string, guard) {
+	m := make([][]string, rows)
+	for i := range m {
+		m[i] = make([]string, cols)
+		for j := range m[i] {
+			m[i][j] = "."
+			if r.Intn(8) == 0 {
+				m[i][j] = "#"
+			}
+		}
+	}
+	g := guard{
+		row:       rows/4 + r.Intn(rows/2),
+		col:       cols/4 + r.Intn(cols/2),
+		direction: []string{"up", "right", "down", "left"}[r.Intn(4)],
+	}
+	m[g.row][g.col] = charForDirection(g.direction)
+	return m, g
+}
+
+func formatMap(m [][]string) string {
+	var sb strings.Builder
+	for _, row := range m {
+		sb.WriteString(strings.Join(row, ""))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+const demoMap = `....#.....
+.........#
+..........
+..#.......
+.......#..
+..........
+.#..^.....
+........#.
+#.........
+......#...`
+
 func BenchmarkTraverse(b *testing.B) {
 	m := [][]string{
 		{".", ".", "#", ".", "."},
@@ -167,6 +784,55 @@ func BenchmarkTraverse(b *testing.B) {
 		{".", ".", ".", "#", "."},
 	}
 	for i := 0; i < b.N; i++ {
-		traverse(m, 2, 4, 0, 0, 0)
+		traverse(m, guard{row: 4, col: 2, direction: "up"}, nil)
+	}
+}
+
+func benchmarkPart2(b *testing.B, workers int) {
+	r := rand.New(rand.NewSource(2))
+	var m [][]string
+	var start guard
+	for {
+		m, start = randomMap(r, 130, 130)
+		if _, result := traverse(m, start, nil); result == exited && len(guardPath(m, start)) > 500 {
+			break
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		part2(m, start, workers)
+	}
+}
+
+func BenchmarkPart2Sequential(b *testing.B) {
+	benchmarkPart2(b, 1)
+}
+
+func BenchmarkPart2Parallel(b *testing.B) {
+	benchmarkPart2(b, runtime.GOMAXPROCS(0))
+}
+
+func BenchmarkLoopCheckTraverse(b *testing.B) {
+	m, guards, err := parseMap(demoMap)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m[7][6] = "#"
+	for i := 0; i < b.N; i++ {
+		traverse(m, guards[0], nil)
+	}
+}
+
+func BenchmarkLoopCheckGrid(b *testing.B) {
+	m, guards, err := parseMap(demoMap)
+	if err != nil {
+		b.Fatal(err)
+	}
+	g := newGrid(m)
+	start := g.index(guards[0].row, guards[0].col)
+	for i := 0; i < b.N; i++ {
+		undo := g.addObstruction(7, 6)
+		g.loops(start, dirUp)
+		undo()
 	}
 }
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/big"
 	"os"
+	"os/signal"
+	"runtime"
 	"runtime/pprof"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
@@ -19,9 +28,13 @@ var (
 	demo        = flag.Bool("demo", false, "Use demo input")
 	enablePprof = flag.Bool("pprof", false, "Enable pprof")
 	verbose     = flag.Bool("v", false, "Enable verbose output")
+	show        = flag.String("show", "", "List how each equation is solved: the first solution (first), all solutions (all) or how many there are (count)")
+	extraOps    = flag.String("ops", "", "Also sum the equations solvable with these comma-separated operators, from +, -, * and ||")
+	workers     = flag.Int("workers", runtime.GOMAXPROCS(0), "Number of workers solving equations")
+	precedence  = flag.Bool("precedence", false, "Evaluate || before *, and * before + and -, instead of left to right")
 
-	partOneOperators = []string{"+", "*"}
-	partTwoOperators = []string{"+", "*", "|"}
+	partOneOperators = mustParseOperators("+,*")
+	partTwoOperators = mustParseOperators("+,*,||")
 )
 
 func main() {
@@ -49,18 +62,59 @@ func main() {
 		log.Fatal(err)
 	}
 
-	data, err := parse(input)
+	equations, err := parse(input)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	partOneSum, partTwoSum, err := compute(data)
+	partOne, partTwo := partOneOperators, partTwoOperators
+	partOne.precedence = *precedence
+	partTwo.precedence = *precedence
+
+	var extra operatorSet
+	if *extraOps != "" {
+		extra, err = parseOperators(*extraOps)
+		if err != nil {
+			log.Fatal(err)
+		}
+		extra.precedence = *precedence
+	}
+
+	if *show != "" {
+		fmt.Println("Part One equations:")
+		if err := writeSolutions(os.Stdout, equations, partOne, *show); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Part Two equations:")
+		if err := writeSolutions(os.Stdout, equations, partTwo, *show); err != nil {
+			log.Fatal(err)
+		}
+		if *extraOps != "" {
+			fmt.Printf("Operators %v equations:\n", extra)
+			if err := writeSolutions(os.Stdout, equations, extra, *show); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	partOneSum, partTwoSum, err := compute(ctx, equations, partOne, partTwo, *workers)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	fmt.Printf("Part One: %d\n", partOneSum)
 	fmt.Printf("Part Two: %d\n", partTwoSum)
+
+	if *extraOps != "" {
+		sum, err := sumSolvable(ctx, equations, extra, *workers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Operators %v: %d\n", extra, sum)
+	}
 }
 
 func readInputFile(file string) (string, error) {
@@ -71,136 +125,739 @@ func readInputFile(file string) (string, error) {
 	return string(bytes), nil
 }
 
-func parse(input string) (map[int][]int, error) {
-	data := make(map[int][]int)
+// Equation is one line of the input: the operands that should combine into
+// the target, and the line they were read from.
+type Equation struct {
+	Target int
+	// BigTarget holds the target instead of Target when it does not fit in
+	// an int.
+	BigTarget *big.Int
+	Operands  []int
+	Line      int
+}
+
+// target returns the target whatever its size.
+func (e Equation) target() *big.Int {
+	if e.BigTarget != nil {
+		return e.BigTarget
+	}
+	return big.NewInt(int64(e.Target))
+}
 
-	for _, line := range strings.Split(input, "\n") {
-		sections := strings.Split(line, ":")
-		if len(sections) != 2 {
-			return nil, fmt.Errorf("invalid input: %s", line)
+func parse(input string) ([]Equation, error) {
+	var equations []Equation
+	for i, line := range strings.Split(strings.TrimRight(input, "\r\n"), "\n") {
+		equation, err := parseEquation(strings.TrimSuffix(line, "\r"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
 		}
-		sum, err := strconv.Atoi(sections[0])
+		equation.Line = i + 1
+		equations = append(equations, equation)
+	}
+	return equations, nil
+}
+
+func parseEquation(line string) (Equation, error) {
+	sections := strings.Split(line, ":")
+	if len(sections) != 2 {
+		return Equation{}, fmt.Errorf("invalid equation %q: expected a target and operands separated by :", line)
+	}
+	var equation Equation
+	target, err := parseNumber(sections[0])
+	if errors.Is(err, strconv.ErrRange) {
+		equation.BigTarget, err = parseBigNumber(sections[0])
+	}
+	if err != nil {
+		return Equation{}, fmt.Errorf("invalid equation %q: %w", line, err)
+	}
+	equation.Target = target
+	for _, field := range strings.Fields(sections[1]) {
+		n, err := parseNumber(field)
 		if err != nil {
-			return nil, err
+			return Equation{}, fmt.Errorf("invalid equation %q: %w", line, err)
+		}
+		equation.Operands = append(equation.Operands, n)
+	}
+	if len(equation.Operands) == 0 {
+		return Equation{}, fmt.Errorf("invalid equation %q: no operands", line)
+	}
+	return equation, nil
+}
+
+// parseNumber parses a non-negative int. Numbers too large for an int are
+// reported with an error wrapping strconv.ErrRange.
+func parseNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(s, "-") {
+		return 0, fmt.Errorf("number %q is too large: %w", s, strconv.ErrRange)
+	}
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func parseBigNumber(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+// compute returns the sums of the targets of the equations solvable with the
+// operators of each part. Every equation is checked for both parts at once,
+// and when part two's operators include part one's, an equation solvable in
+// part one is not searched again. The sums can grow larger than an int.
+func compute(ctx context.Context, equations []Equation, partOne, partTwo operatorSet, workers int) (*big.Int, *big.Int, error) {
+	oneImpliesTwo := partTwo.includes(partOne)
+	solved, err := solveEach(ctx, equations, workers, func(e Equation) [2]bool {
+		one := solvable(e, partOne)
+		return [2]bool{one, one && oneImpliesTwo || solvable(e, partTwo)}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	partOneSum := new(big.Int)
+	partTwoSum := new(big.Int)
+	for i, e := range equations {
+		if *verbose {
+			fmt.Printf("%s: part one %t, part two %t\n", formatEquation(e), solved[i][0], solved[i][1])
+		}
+		if solved[i][0] {
+			partOneSum.Add(partOneSum, e.target())
+		}
+		if solved[i][1] {
+			partTwoSum.Add(partTwoSum, e.target())
+		}
+	}
+	return partOneSum, partTwoSum, nil
+}
+
+// sumSolvable returns the sum of the targets of the equations solvable with
+// the operators.
+func sumSolvable(ctx context.Context, equations []Equation, operators operatorSet, workers int) (*big.Int, error) {
+	solved, err := solveEach(ctx, equations, workers, func(e Equation) bool {
+		return solvable(e, operators)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sum := new(big.Int)
+	for i, e := range equations {
+		if *verbose {
+			fmt.Printf("%s: solvable with %v %t\n", formatEquation(e), operators, solved[i])
+		}
+		if solved[i] {
+			sum.Add(sum, e.target())
 		}
-		numbers := strings.Split(sections[1], " ")
-		convertedNumbers := make([]int, 0)
-		for _, c := range numbers {
-			if c == "" {
-				continue
+	}
+	return sum, nil
+}
+
+// solveEach calls solve for every equation on a pool of workers and returns
+// the results in the order of the equations. Once ctx is done no more
+// equations are started, and its error is returned.
+func solveEach[T any](ctx context.Context, equations []Equation, workers int, solve func(Equation) T) ([]T, error) {
+	results := make([]T, len(equations))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = solve(equations[i])
 			}
-			n, err := strconv.Atoi(c)
-			if err != nil {
-				return nil, err
+		}()
+	}
+feed:
+	for i := range equations {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// operator is a binary operator that can go between the operands of an
+// equation. The right operand is never negative.
+type operator struct {
+	symbol string
+	// precedence orders operators when evaluating with standard precedence:
+	// higher binds tighter.
+	precedence int
+
+	// apply returns a op b, reporting false if it does not fit in an int.
+	apply    func(a, b int) (int, bool)
+	applyBig func(a, b *big.Int) *big.Int
+
+	// undo finds the left operand that gives target, for searching backwards
+	// from the target. It only looks for non-negative left operands, and is
+	// nil for operators that can give a negative result from non-negative
+	// operands.
+	undo func(target, b int) (int, inverse)
+	// undoBig is undo over all integers.
+	undoBig func(target, b *big.Int) (*big.Int, inverse)
+}
+
+// inverse says which left operands give a result with an operator.
+type inverse int
+
+const (
+	noOperand  inverse = iota // none do
+	oneOperand                // only the one returned does
+	anyOperand                // all of them do
+)
+
+var operatorsBySymbol = map[string]operator{
+	"+": {
+		symbol:     "+",
+		precedence: 1,
+		apply:      checkedAdd,
+		applyBig:   func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) },
+		undo: func(target, b int) (int, inverse) {
+			if target < b {
+				return 0, noOperand
+			}
+			return target - b, oneOperand
+		},
+		undoBig: func(target, b *big.Int) (*big.Int, inverse) {
+			return new(big.Int).Sub(target, b), oneOperand
+		},
+	},
+	"-": {
+		symbol:     "-",
+		precedence: 1,
+		apply:      checkedSub,
+		applyBig:   func(a, b *big.Int) *big.Int { return new(big.Int).Sub(a, b) },
+		undoBig: func(target, b *big.Int) (*big.Int, inverse) {
+			return new(big.Int).Add(target, b), oneOperand
+		},
+	},
+	"*": {
+		symbol:     "*",
+		precedence: 2,
+		apply:      checkedMul,
+		applyBig:   func(a, b *big.Int) *big.Int { return new(big.Int).Mul(a, b) },
+		undo: func(target, b int) (int, inverse) {
+			if b == 0 {
+				return 0, zeroProduct(target == 0)
+			}
+			if target%b != 0 {
+				return 0, noOperand
+			}
+			return target / b, oneOperand
+		},
+		undoBig: func(target, b *big.Int) (*big.Int, inverse) {
+			if b.Sign() == 0 {
+				return nil, zeroProduct(target.Sign() == 0)
 			}
-			convertedNumbers = append(convertedNumbers, n)
+			q, r := new(big.Int).QuoRem(target, b, new(big.Int))
+			if r.Sign() != 0 {
+				return nil, noOperand
+			}
+			return q, oneOperand
+		},
+	},
+	"||": {
+		symbol:     "||",
+		precedence: 3,
+		apply: func(a, b int) (int, bool) {
+			n, err := concatNumbers(a, b)
+			return n, err == nil
+		},
+		applyBig: concatBig,
+		undo: func(target, b int) (int, inverse) {
+			if a, ok := stripNumber(target, b); ok {
+				return a, oneOperand
+			}
+			return 0, noOperand
+		},
+		undoBig: stripBig,
+	},
+}
+
+// zeroProduct is the inverse of multiplying by zero: anything times zero is
+// zero.
+func zeroProduct(targetIsZero bool) inverse {
+	if targetIsZero {
+		return anyOperand
+	}
+	return noOperand
+}
+
+// operatorSet is the operators that can go between the operands of an
+// equation, and how expressions using them are evaluated.
+type operatorSet struct {
+	operators []operator
+	// precedence evaluates expressions with standard operator precedence
+	// instead of strictly left to right.
+	precedence bool
+}
+
+// parseOperators parses a comma-separated list of operator symbols.
+func parseOperators(s string) (operatorSet, error) {
+	var set operatorSet
+	seen := make(map[string]bool)
+	for _, symbol := range strings.Split(s, ",") {
+		symbol = strings.TrimSpace(symbol)
+		op, ok := operatorsBySymbol[symbol]
+		if !ok {
+			return operatorSet{}, fmt.Errorf("unknown operator %q", symbol)
 		}
-		data[sum] = convertedNumbers
+		if seen[symbol] {
+			return operatorSet{}, fmt.Errorf("operator %q is listed more than once", symbol)
+		}
+		seen[symbol] = true
+		set.operators = append(set.operators, op)
 	}
+	return set, nil
+}
 
-	return data, nil
+func mustParseOperators(s string) operatorSet {
+	set, err := parseOperators(s)
+	if err != nil {
+		panic(err)
+	}
+	return set
 }
 
-func compute(data map[int][]int) (int, int, error) {
-	partOneSum := 0
-	partTwoSum := 0
+func (set operatorSet) String() string {
+	return strings.Join(symbols(set.operators), ",")
+}
 
-	for k, v := range data {
-		c, err := computeWithPerms(k, v, partOneOperators)
-		if err != nil {
-			return -1, -1, err
+// nonNegative reports whether every operator can be undone with ints, so
+// equations with int targets can be searched without math/big.
+func (set operatorSet) nonNegative() bool {
+	for _, op := range set.operators {
+		if op.undo == nil {
+			return false
 		}
-		partOneSum += c
 	}
+	return true
+}
 
-	for k, v := range data {
-		c, err := computeWithPerms(k, v, partTwoOperators)
-		if err != nil {
-			return -1, -1, err
+// includes reports whether every expression built from the other set's
+// operators can also be built from this set's, and is evaluated the same way.
+func (set operatorSet) includes(other operatorSet) bool {
+	if set.precedence != other.precedence {
+		return false
+	}
+	for _, op := range other.operators {
+		if !slices.ContainsFunc(set.operators, func(o operator) bool { return o.symbol == op.symbol }) {
+			return false
 		}
-		partTwoSum += c
 	}
+	return true
+}
 
-	return partOneSum, partTwoSum, nil
+func symbols(ops []operator) []string {
+	s := make([]string, len(ops))
+	for i, op := range ops {
+		s[i] = op.symbol
+	}
+	return s
+}
+
+// solvable reports whether the operators can combine the operands of the
+// equation into its target.
+func solvable(e Equation, operators operatorSet) bool {
+	found := false
+	findSolutions(e, operators, func([]operator) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// firstSolution returns the symbols of the operators of the first solution
+// found, if any.
+func firstSolution(e Equation, operators operatorSet) ([]string, bool) {
+	var first []string
+	findSolutions(e, operators, func(ops []operator) bool {
+		first = symbols(ops)
+		return false
+	})
+	return first, first != nil
+}
+
+// allSolutions returns the symbols of the operators of every solution.
+func allSolutions(e Equation, operators operatorSet) [][]string {
+	var all [][]string
+	findSolutions(e, operators, func(ops []operator) bool {
+		all = append(all, symbols(ops))
+		return true
+	})
+	return all
+}
+
+func countSolutions(e Equation, operators operatorSet) int {
+	count := 0
+	findSolutions(e, operators, func([]operator) bool {
+		count++
+		return true
+	})
+	return count
 }
 
-func computeWithPerms(total int, numbers []int, operators []string) (int, error) {
-	perms := generateOperatorPermutations(operators, len(numbers)-1)
-	if *verbose {
-		fmt.Println("--------------------")
-		fmt.Printf("%d: %v\n", total, numbers)
-		fmt.Printf("Perms: %v\n", perms)
+// findSolutions calls visit with every assignment of operators that combines
+// the operands of the equation into its target, until visit returns false.
+// ops[i] goes between operands i and i+1, and is only valid until visit
+// returns.
+//
+// Evaluating left to right, the search works backwards from the target,
+// undoing the last operation at each step, and drops a branch as soon as its
+// operation cannot be undone. With operators that keep results non-negative a
+// target that starts as an int stays one, and a larger target is searched
+// with math/big until it fits. Other operators are searched with math/big
+// throughout. With standard precedence there is no last operation to undo,
+// so every assignment is evaluated in turn.
+func findSolutions(e Equation, operators operatorSet, visit func(ops []operator) bool) {
+	s := solutionSearch{
+		e:           e,
+		operators:   operators.operators,
+		nonNegative: operators.nonNegative(),
+		ops:         make([]operator, len(e.Operands)-1),
+		visit:       visit,
 	}
-	for _, perm := range perms {
-		parsedPerms := strings.Split(perm, "")
-		if *verbose {
-			fmt.Printf("Parsed perms: %v\n", parsedPerms)
-		}
-		sum := 0
-		for i, num := range numbers {
-			if num == -1 {
-				continue
-			}
-			if sum == 0 {
-				sum = num
-				continue
-			}
-			switch parsedPerms[i-1] {
-			case "+":
-				sum += num
-			case "*":
-				sum *= num
-			case "|":
-				var err error
-				sum, err = concatNumbers(sum, num)
-				if err != nil {
-					return -1, err
-				}
-			default:
-				fmt.Printf("Invalid operator: %s\n", parsedPerms[i-1])
-				continue
+	switch {
+	case operators.precedence:
+		s.enumerate(0)
+	case e.BigTarget == nil && s.nonNegative:
+		s.search(e.Target, len(e.Operands))
+	default:
+		s.searchBig(e.target(), len(e.Operands))
+	}
+}
+
+type solutionSearch struct {
+	e           Equation
+	operators   []operator
+	nonNegative bool
+	ops         []operator
+	visit       func([]operator) bool
+}
+
+// search looks for the operators combining the first n operands into target.
+// It returns false once visit has asked to stop.
+func (s *solutionSearch) search(target, n int) bool {
+	if n == 1 {
+		if target != s.e.Operands[0] {
+			return true
+		}
+		return s.visit(s.ops)
+	}
+	last := s.e.Operands[n-1]
+	for _, op := range s.operators {
+		s.ops[n-2] = op
+		switch a, inv := op.undo(target, last); inv {
+		case oneOperand:
+			if !s.search(a, n-1) {
+				return false
+			}
+		case anyOperand:
+			if !s.any(n - 3) {
+				return false
 			}
 		}
+	}
+	return true
+}
 
-		if *verbose {
-			fmt.Printf("Sum: %v\n", sum)
+// searchBig is search for targets of any size.
+func (s *solutionSearch) searchBig(target *big.Int, n int) bool {
+	if s.nonNegative {
+		if target.Sign() < 0 {
+			return true
+		}
+		if target.IsInt64() && target.Int64() <= math.MaxInt {
+			return s.search(int(target.Int64()), n)
+		}
+	}
+	if n == 1 {
+		if target.Cmp(big.NewInt(int64(s.e.Operands[0]))) != 0 {
+			return true
+		}
+		return s.visit(s.ops)
+	}
+	last := big.NewInt(int64(s.e.Operands[n-1]))
+	for _, op := range s.operators {
+		s.ops[n-2] = op
+		switch a, inv := op.undoBig(target, last); inv {
+		case oneOperand:
+			if !s.searchBig(a, n-1) {
+				return false
+			}
+		case anyOperand:
+			if !s.any(n - 3) {
+				return false
+			}
 		}
+	}
+	return true
+}
 
-		if sum == total {
-			if *verbose {
-				fmt.Printf("Sum %v matches with perm: %s\n", sum, perm)
+// any visits every assignment of the operators up to ops[i].
+func (s *solutionSearch) any(i int) bool {
+	if i < 0 {
+		return s.visit(s.ops)
+	}
+	for _, op := range s.operators {
+		s.ops[i] = op
+		if !s.any(i - 1) {
+			return false
+		}
+	}
+	return true
+}
+
+// enumerate visits every assignment of the operators from ops[i] on that
+// evaluates to the target with standard precedence.
+func (s *solutionSearch) enumerate(i int) bool {
+	if i == len(s.ops) {
+		if !s.matches() {
+			return true
+		}
+		return s.visit(s.ops)
+	}
+	for _, op := range s.operators {
+		s.ops[i] = op
+		if !s.enumerate(i + 1) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *solutionSearch) matches() bool {
+	if result, ok := evaluate(s.e.Operands, s.ops, true); ok {
+		return s.e.BigTarget == nil && result == s.e.Target
+	}
+	return evaluateBig(s.e.Operands, s.ops, true).Cmp(s.e.target()) == 0
+}
+
+// formatSolution writes out the equation with the operators filled in.
+func formatSolution(e Equation, ops []string) string {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(e.Operands[0]))
+	for i, op := range ops {
+		fmt.Fprintf(&sb, " %s %d", op, e.Operands[i+1])
+	}
+	fmt.Fprintf(&sb, " = %d", e.target())
+	return sb.String()
+}
+
+func formatEquation(e Equation) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d:", e.target())
+	for _, n := range e.Operands {
+		fmt.Fprintf(&sb, " %d", n)
+	}
+	return sb.String()
+}
+
+// writeSolutions lists every equation by line, with the first solution found,
+// all of its solutions or the number of them, depending on mode.
+func writeSolutions(w io.Writer, equations []Equation, operators operatorSet, mode string) error {
+	for _, e := range equations {
+		var lines []string
+		switch mode {
+		case "first":
+			if ops, ok := firstSolution(e, operators); ok {
+				lines = append(lines, formatSolution(e, ops))
+			}
+		case "all":
+			for _, ops := range allSolutions(e, operators) {
+				lines = append(lines, formatSolution(e, ops))
+			}
+		case "count":
+			switch n := countSolutions(e, operators); n {
+			case 0:
+			case 1:
+				lines = append(lines, formatEquation(e)+" has 1 solution")
+			default:
+				lines = append(lines, fmt.Sprintf("%s has %d solutions", formatEquation(e), n))
 			}
-			return sum, nil
+		default:
+			return fmt.Errorf("unknown show mode %q", mode)
+		}
+		if len(lines) == 0 {
+			lines = append(lines, formatEquation(e)+" is unsolvable")
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintf(w, "line %d: %s\n", e.Line, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stripNumber undoes concatNumbers, returning a such that a concatenated with
+// b is n, if there is one.
+func stripNumber(n, b int) (int, bool) {
+	if n < b {
+		return 0, false
+	}
+	shift, ok := digitShift(b)
+	if !ok {
+		// n has no more digits than b, so there is nothing to strip.
+		return 0, n == b
+	}
+	if n%shift != b {
+		return 0, false
+	}
+	return n / shift, true
+}
+
+// stripBig is stripNumber over all integers, where a negative left operand
+// keeps its sign when concatenated.
+func stripBig(target, b *big.Int) (*big.Int, inverse) {
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(target), bigDigitShift(b), new(big.Int))
+	if r.Cmp(b) != 0 {
+		return nil, noOperand
+	}
+	if target.Sign() < 0 {
+		if q.Sign() == 0 {
+			return nil, noOperand
 		}
+		q.Neg(q)
 	}
-	return 0, nil
+	return q, oneOperand
 }
 
-func generateOperatorPermutations(operators []string, length int) []string {
-	if length == 1 {
-		return operators
+// evaluate applies the operators to the numbers, either left to right or
+// with standard precedence. It reports false if any intermediate result
+// overflows an int.
+func evaluate(numbers []int, ops []operator, precedence bool) (int, bool) {
+	return evaluateExpression(numbers, ops, precedence, func(op operator, a, b int) (int, bool) {
+		return op.apply(a, b)
+	})
+}
+
+// evaluateBig is evaluate without a limit on the size of the results.
+func evaluateBig(numbers []int, ops []operator, precedence bool) *big.Int {
+	values := make([]*big.Int, len(numbers))
+	for i, n := range numbers {
+		values[i] = big.NewInt(int64(n))
 	}
+	result, _ := evaluateExpression(values, ops, precedence, func(op operator, a, b *big.Int) (*big.Int, bool) {
+		return op.applyBig(a, b), true
+	})
+	return result
+}
 
-	perms := make([]string, 0)
-	for _, op := range operators {
-		for _, p := range generateOperatorPermutations(operators, length-1) {
-			perms = append(perms, op+p)
+// evaluateExpression evaluates the expression with a stack of the operations
+// still waiting for their right operand to be complete. Operations of equal
+// precedence are applied left to right.
+func evaluateExpression[T any](numbers []T, ops []operator, precedence bool, apply func(op operator, a, b T) (T, bool)) (T, bool) {
+	values := []T{numbers[0]}
+	var pending []operator
+	reduce := func() bool {
+		op := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		a, b := values[len(values)-2], values[len(values)-1]
+		values = values[:len(values)-1]
+		result, ok := apply(op, a, b)
+		values[len(values)-1] = result
+		return ok
+	}
+	for i, op := range ops {
+		for len(pending) > 0 && (!precedence || pending[len(pending)-1].precedence >= op.precedence) {
+			if !reduce() {
+				return values[0], false
+			}
+		}
+		pending = append(pending, op)
+		values = append(values, numbers[i+1])
+	}
+	for len(pending) > 0 {
+		if !reduce() {
+			return values[0], false
 		}
 	}
+	return values[0], true
+}
+
+var errOverflow = errors.New("integer overflow")
 
-	return perms
+// checkedAdd adds a non-negative b to a, reporting false on overflow.
+func checkedAdd(a, b int) (int, bool) {
+	if a > math.MaxInt-b {
+		return 0, false
+	}
+	return a + b, true
 }
 
+// checkedSub subtracts a non-negative b from a, reporting false on overflow.
+func checkedSub(a, b int) (int, bool) {
+	if a < math.MinInt+b {
+		return 0, false
+	}
+	return a - b, true
+}
+
+// checkedMul multiplies a by a non-negative b, reporting false on overflow.
+func checkedMul(a, b int) (int, bool) {
+	if b != 0 && (a > math.MaxInt/b || a < math.MinInt/b) {
+		return 0, false
+	}
+	return a * b, true
+}
+
+// concatNumbers appends the digits of a non-negative b to a, returning
+// errOverflow if the result does not fit in an int. A negative a keeps its
+// sign, so -12 concatenated with 3 is -123.
 func concatNumbers(a, b int) (int, error) {
-	aStr := strconv.Itoa(a)
-	bStr := strconv.Itoa(b)
+	shift, ok := digitShift(b)
+	switch {
+	case !ok && a != 0:
+		return -1, errOverflow
+	case !ok:
+		return b, nil
+	case a >= 0 && a > (math.MaxInt-b)/shift:
+		return -1, errOverflow
+	case a < 0 && a < (math.MinInt+b)/shift:
+		return -1, errOverflow
+	case a < 0:
+		return a*shift - b, nil
+	}
+	return a*shift + b, nil
+}
 
-	aStr += bStr
+// concatBig is concatNumbers without a limit on the size of the result.
+func concatBig(a, b *big.Int) *big.Int {
+	result := new(big.Int).Mul(a, bigDigitShift(b))
+	if a.Sign() < 0 {
+		return result.Sub(result, b)
+	}
+	return result.Add(result, b)
+}
 
-	str, err := strconv.Atoi(aStr)
-	if err != nil {
-		return -1, err
+// digitShift returns the power of ten that moves a number left past the
+// digits of b, or false if it does not fit in an int.
+func digitShift(b int) (int, bool) {
+	shift := 10
+	for shift <= b {
+		if shift > math.MaxInt/10 {
+			return 0, false
+		}
+		shift *= 10
 	}
+	return shift, true
+}
 
-	return str, nil
+func bigDigitShift(b *big.Int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(len(b.String()))), nil)
 }
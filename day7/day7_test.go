@@ -1,6 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -27,29 +36,72 @@ func TestConcatNumbers(t *testing.T) {
 	}
 }
 
+// computeWithPerms tries every operator permutation in turn. It is the
+// reference the searches in day7.go are checked against.
+func computeWithPerms(total int, numbers []int, operators operatorSet) int {
+	perms := generateOperatorPermutations(symbols(operators.operators), len(numbers)-1)
+	for _, perm := range perms {
+		ops := operatorsFor(perm)
+		sum, ok := evaluate(numbers, ops, operators.precedence)
+		if !ok {
+			// An intermediate result overflowed, but later operations can
+			// still bring it back to the total.
+			if evaluateBig(numbers, ops, operators.precedence).Cmp(big.NewInt(int64(total))) != 0 {
+				continue
+			}
+			sum = total
+		}
+		if sum == total {
+			return sum
+		}
+	}
+	return 0
+}
+
+func generateOperatorPermutations(operators []string, length int) [][]string {
+	if length == 0 {
+		return [][]string{{}}
+	}
+
+	perms := make([][]string, 0)
+	for _, op := range operators {
+		for _, p := range generateOperatorPermutations(operators, length-1) {
+			perms = append(perms, append([]string{op}, p...))
+		}
+	}
+
+	return perms
+}
+
+func operatorsFor(symbols []string) []operator {
+	ops := make([]operator, len(symbols))
+	for i, symbol := range symbols {
+		ops[i] = operatorsBySymbol[symbol]
+	}
+	return ops
+}
+
 func TestGenerateOperatorPermutations(t *testing.T) {
 	tests := []struct {
 		operators []string
 		length    int
-		expected  []string
+		expected  [][]string
 	}{
-		{[]string{"+", "*"}, 1, []string{"+", "*"}},
-		{[]string{"+", "*"}, 2, []string{"++", "+*", "*+", "**"}},
-		{[]string{"+", "*", "|"}, 1, []string{"+", "*", "|"}},
-		{[]string{"+", "*", "|"}, 2, []string{"++", "+*", "+|", "*+", "**", "*|", "|+", "|*", "||"}},
+		{[]string{"+", "*"}, 0, [][]string{{}}},
+		{[]string{"+", "*"}, 1, [][]string{{"+"}, {"*"}}},
+		{[]string{"+", "*"}, 2, [][]string{{"+", "+"}, {"+", "*"}, {"*", "+"}, {"*", "*"}}},
+		{[]string{"+", "*", "||"}, 1, [][]string{{"+"}, {"*"}, {"||"}}},
+		{[]string{"+", "*", "||"}, 2, [][]string{
+			{"+", "+"}, {"+", "*"}, {"+", "||"},
+			{"*", "+"}, {"*", "*"}, {"*", "||"},
+			{"||", "+"}, {"||", "*"}, {"||", "||"},
+		}},
 	}
 
 	for _, test := range tests {
 		result := generateOperatorPermutations(test.operators, test.length)
-		if len(result) != len(test.expected) {
+		if !reflect.DeepEqual(result, test.expected) {
 			t.Errorf("generateOperatorPermutations(%v, %d) = %v; want %v", test.operators, test.length, result, test.expected)
-			continue
-		}
-		for i, v := range result {
-			if v != test.expected[i] {
-				t.Errorf("generateOperatorPermutations(%v, %d) = %v; want %v", test.operators, test.length, result, test.expected)
-				break
-			}
 		}
 	}
 }
@@ -58,86 +110,655 @@ func TestComputeWithPerms(t *testing.T) {
 	tests := []struct {
 		total     int
 		numbers   []int
-		operators []string
+		operators operatorSet
 		expected  int
 	}{
-		{10, []int{1, 2, 3, 4}, []string{"+", "*"}, 10},
-		{24, []int{2, 3, 4}, []string{"+", "*"}, 24},
-		{1234, []int{12, 34}, []string{"|"}, 1234},
-		{2, []int{1, 1}, []string{"+"}, 2},
-		{11, []int{1, 1}, []string{"|"}, 11},
+		{10, []int{1, 2, 3, 4}, mustParseOperators("+,*"), 10},
+		{24, []int{2, 3, 4}, mustParseOperators("+,*"), 24},
+		{1234, []int{12, 34}, mustParseOperators("||"), 1234},
+		{2, []int{1, 1}, mustParseOperators("+"), 2},
+		{11, []int{1, 1}, mustParseOperators("||"), 11},
+		{5, []int{0, 5}, mustParseOperators("+"), 5},
+		{5, []int{0, 5}, mustParseOperators("*"), 0},
+		{7, []int{7}, mustParseOperators("+"), 7},
+		{14, []int{2, 3, 4}, operatorSet{operators: partOneOperators.operators, precedence: true}, 14},
+		{20, []int{2, 3, 4}, operatorSet{operators: partOneOperators.operators, precedence: true}, 0},
+	}
+
+	for _, test := range tests {
+		if result := computeWithPerms(test.total, test.numbers, test.operators); result != test.expected {
+			t.Errorf("computeWithPerms(%d, %v, %v) = %d; want %d", test.total, test.numbers, test.operators, result, test.expected)
+		}
+	}
+}
+
+func TestSolvable(t *testing.T) {
+	withMinus := mustParseOperators("+,*,||,-")
+	precedence := operatorSet{operators: withMinus.operators, precedence: true}
+	tests := []struct {
+		target    int
+		numbers   []int
+		operators operatorSet
+		expected  bool
+	}{
+		{190, []int{10, 19}, partOneOperators, true},
+		{3267, []int{81, 40, 27}, partOneOperators, true},
+		{83, []int{17, 5}, partTwoOperators, false},
+		{156, []int{15, 6}, partOneOperators, false},
+		{156, []int{15, 6}, partTwoOperators, true},
+		{7290, []int{6, 8, 6, 15}, partTwoOperators, true},
+		{192, []int{17, 8, 14}, partTwoOperators, true},
+		{21037, []int{9, 7, 18, 13}, partTwoOperators, false},
+		{5, []int{5}, partOneOperators, true},
+		{0, []int{7, 0}, partOneOperators, true},
+		{7, []int{7, 0}, partOneOperators, true},
+		{70, []int{7, 0}, partOneOperators, false},
+		{70, []int{7, 0}, partTwoOperators, true},
+		{5, []int{0, 5}, mustParseOperators("||"), true},
+		{1, []int{2, 3}, partTwoOperators, false},
+		{1, []int{3, 2}, withMinus, true},
+		{0, []int{5, 9, 4}, withMinus, true},
+		{6, []int{5, 9, 10}, withMinus, true},
+		{-41, []int{1, 5, 1}, withMinus, true},
+		{1, []int{2, 3}, withMinus, false},
+		{14, []int{2, 3, 4}, withMinus, false},
+		{14, []int{2, 3, 4}, precedence, true},
+		{-10, []int{2, 3, 4}, precedence, true},
+		{86, []int{2, 3, 4, 5}, precedence, false},
+		{-2, []int{2, 3, 4, 5}, precedence, true},
+		{68, []int{2, 3, 4}, precedence, true},
+		{68, []int{2, 3, 4}, withMinus, false},
+		{64, []int{2, 3, 4}, withMinus, true},
+		{64, []int{2, 3, 4}, precedence, false},
 	}
 
 	for _, test := range tests {
-		result, err := computeWithPerms(test.total, test.numbers, test.operators)
+		if result := solvable(Equation{Target: test.target, Operands: test.numbers}, test.operators); result != test.expected {
+			t.Errorf("solvable(%d, %v, %v) = %v; want %v", test.target, test.numbers, test.operators, result, test.expected)
+		}
+	}
+}
+
+func TestStripNumber(t *testing.T) {
+	tests := []struct {
+		n, b     int
+		expected int
+		ok       bool
+	}{
+		{1234, 34, 12, true},
+		{1234, 4, 123, true},
+		{1234, 1234, 0, true},
+		{1230, 0, 123, true},
+		{1100, 100, 1, true},
+		{1000, 100, 0, false},
+		{math.MaxInt, 7, math.MaxInt / 10, true},
+		{math.MaxInt, 223372036854775807, 9, true},
+		{math.MaxInt, math.MaxInt, 0, true},
+		{math.MaxInt - 1, math.MaxInt, 0, false},
+		{math.MaxInt, math.MaxInt - 1, 0, false},
+		{1234, 35, 0, false},
+		{1234, 12345, 0, false},
+		{100, 10, 0, false},
+	}
+
+	for _, test := range tests {
+		result, ok := stripNumber(test.n, test.b)
+		if result != test.expected || ok != test.ok {
+			t.Errorf("stripNumber(%d, %d) = %d, %v; want %d, %v", test.n, test.b, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestSolvableMatchesPermutations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for range 2000 {
+		target, numbers := randomEquation(r, 2+r.Intn(5))
+		if r.Intn(2) == 0 {
+			// Most nearby targets cannot be reached.
+			target += r.Intn(5)
+		}
+		for _, operators := range []operatorSet{partOneOperators, partTwoOperators} {
+			sum := computeWithPerms(target, numbers, operators)
+			if expected := sum == target; solvable(Equation{Target: target, Operands: numbers}, operators) != expected {
+				t.Errorf("solvable(%d, %v, %v) = %v; want %v", target, numbers, operators, !expected, expected)
+			}
+		}
+	}
+}
+
+func TestSolutions(t *testing.T) {
+	tests := []struct {
+		target    int
+		numbers   []int
+		operators operatorSet
+		expected  [][]string
+	}{
+		{190, []int{10, 19}, partOneOperators, [][]string{{"*"}}},
+		{3267, []int{81, 40, 27}, partOneOperators, [][]string{{"*", "+"}, {"+", "*"}}},
+		{83, []int{17, 5}, partTwoOperators, nil},
+		{7290, []int{6, 8, 6, 15}, partTwoOperators, [][]string{{"*", "||", "*"}}},
+		{5, []int{5}, partOneOperators, [][]string{{}}},
+		// Multiplying by zero allows any operators before it.
+		{0, []int{2, 3, 0}, partTwoOperators, [][]string{{"+", "*"}, {"*", "*"}, {"||", "*"}}},
+		{4, []int{2, 2}, partTwoOperators, [][]string{{"+"}, {"*"}}},
+	}
+
+	for _, test := range tests {
+		e := Equation{Target: test.target, Operands: test.numbers}
+		all := allSolutions(e, test.operators)
+		if !reflect.DeepEqual(all, test.expected) {
+			t.Errorf("allSolutions(%d, %v, %v) = %v; want %v", test.target, test.numbers, test.operators, all, test.expected)
+		}
+		if count := countSolutions(e, test.operators); count != len(test.expected) {
+			t.Errorf("countSolutions(%d, %v, %v) = %d; want %d", test.target, test.numbers, test.operators, count, len(test.expected))
+		}
+		first, ok := firstSolution(e, test.operators)
+		if ok != (len(test.expected) > 0) || ok && !reflect.DeepEqual(first, test.expected[0]) {
+			t.Errorf("firstSolution(%d, %v, %v) = %v, %v; want the first of %v", test.target, test.numbers, test.operators, first, ok, test.expected)
+		}
+	}
+}
+
+func TestCountSolutionsMatchesPermutations(t *testing.T) {
+	withMinus := mustParseOperators("+,-,*,||")
+	sets := []operatorSet{
+		partOneOperators,
+		partTwoOperators,
+		withMinus,
+		{operators: partTwoOperators.operators, precedence: true},
+		{operators: withMinus.operators, precedence: true},
+	}
+	r := rand.New(rand.NewSource(2))
+	for range 1000 {
+		numbers := make([]int, 1+r.Intn(6))
+		for i := range numbers {
+			numbers[i] = r.Intn(10)
+		}
+		for _, operators := range sets {
+			checkCountSolutions(t, r, numbers, operators)
+		}
+	}
+}
+
+func TestSolutionsMatchBigArithmetic(t *testing.T) {
+	sets := []operatorSet{
+		partTwoOperators,
+		mustParseOperators("+,-,*,||"),
+		{operators: partTwoOperators.operators, precedence: true},
+	}
+	r := rand.New(rand.NewSource(3))
+	sizes := []int{0, 1, 9, 10, 99, 1 << 31, math.MaxInt / 10, math.MaxInt / 2, math.MaxInt - 1, math.MaxInt}
+	for range 500 {
+		numbers := make([]int, 2+r.Intn(3))
+		for i := range numbers {
+			numbers[i] = sizes[r.Intn(len(sizes))]
+		}
+		for _, operators := range sets {
+			checkCountSolutions(t, r, numbers, operators)
+		}
+	}
+}
+
+// checkCountSolutions compares countSolutions against trying every operator
+// permutation, for a target that is usually, but not always, solvable.
+func checkCountSolutions(t *testing.T, r *rand.Rand, numbers []int, operators operatorSet) {
+	t.Helper()
+	perms := generateOperatorPermutations(symbols(operators.operators), len(numbers)-1)
+	target := evaluateBig(numbers, operatorsFor(perms[r.Intn(len(perms))]), operators.precedence)
+	if r.Intn(4) == 0 {
+		target.Add(target, big.NewInt(int64(r.Intn(3))))
+	}
+	e := Equation{Operands: numbers}
+	if target.IsInt64() {
+		e.Target = int(target.Int64())
+	} else {
+		e.BigTarget = target
+	}
+
+	expected := 0
+	for _, perm := range perms {
+		if evaluateBig(numbers, operatorsFor(perm), operators.precedence).Cmp(target) == 0 {
+			expected++
+		}
+	}
+	if count := countSolutions(e, operators); count != expected {
+		t.Errorf("countSolutions(%s: %v, %v, precedence %v) = %d; want %d", target, numbers, operators, operators.precedence, count, expected)
+	}
+	if e.BigTarget == nil && expected > 0 {
+		if sum := computeWithPerms(e.Target, numbers, operators); sum != e.Target {
+			t.Errorf("computeWithPerms(%d, %v, %v, precedence %v) = %d; want %d", e.Target, numbers, operators, operators.precedence, sum, e.Target)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		numbers    []int
+		ops        []string
+		precedence bool
+		expected   int
+	}{
+		{[]int{2, 3, 4}, []string{"+", "*"}, false, 20},
+		{[]int{2, 3, 4}, []string{"+", "*"}, true, 14},
+		{[]int{2, 3, 4}, []string{"*", "+"}, true, 10},
+		{[]int{10, 2, 3}, []string{"-", "-"}, true, 5},
+		{[]int{10, 2, 3}, []string{"-", "+"}, true, 11},
+		{[]int{1, 2, 3}, []string{"||", "*"}, true, 36},
+		{[]int{2, 1, 2}, []string{"*", "||"}, true, 24},
+		{[]int{2, 1, 2}, []string{"*", "||"}, false, 22},
+		{[]int{1, 5, 2}, []string{"-", "||"}, false, -42},
+		{[]int{1, 5, 2}, []string{"-", "||"}, true, -51},
+		{[]int{1, 2, 3, 4, 5}, []string{"+", "*", "-", "*"}, true, -13},
+		{[]int{7}, nil, true, 7},
+	}
+
+	for _, test := range tests {
+		result, ok := evaluate(test.numbers, operatorsFor(test.ops), test.precedence)
+		if !ok || result != test.expected {
+			t.Errorf("evaluate(%v, %v, %v) = %d, %v; want %d", test.numbers, test.ops, test.precedence, result, ok, test.expected)
+		}
+		if result := evaluateBig(test.numbers, operatorsFor(test.ops), test.precedence); result.Cmp(big.NewInt(int64(test.expected))) != 0 {
+			t.Errorf("evaluateBig(%v, %v, %v) = %s; want %d", test.numbers, test.ops, test.precedence, result, test.expected)
+		}
+	}
+}
+
+func TestParseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+		err      string
+	}{
+		{input: "+,*", expected: []string{"+", "*"}},
+		{input: "+, *, ||, -", expected: []string{"+", "*", "||", "-"}},
+		{input: "||", expected: []string{"||"}},
+		{input: "+,|", err: `unknown operator "|"`},
+		{input: "+,/", err: `unknown operator "/"`},
+		{input: "", err: `unknown operator ""`},
+		{input: "+,*,", err: `unknown operator ""`},
+		{input: "+,*,+", err: `operator "+" is listed more than once`},
+	}
+
+	for _, test := range tests {
+		result, err := parseOperators(test.input)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("parseOperators(%q) error = %v; want %s", test.input, err, test.err)
+			}
+			continue
+		}
 		if err != nil {
-			t.Errorf("computeWithPerms(%d, %v, %v) returned error: %v", test.total, test.numbers, test.operators, err)
+			t.Errorf("parseOperators(%q) returned error: %v", test.input, err)
+			continue
 		}
-		if result != test.expected {
-			t.Errorf("computeWithPerms(%d, %v, %v) = %d; want %d", test.total, test.numbers, test.operators, result, test.expected)
+		if symbols := symbols(result.operators); !reflect.DeepEqual(symbols, test.expected) {
+			t.Errorf("parseOperators(%q) = %v; want %v", test.input, symbols, test.expected)
+		}
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	tests := []struct {
+		name     string
+		f        func(a, b int) (int, bool)
+		a, b     int
+		expected int
+		ok       bool
+	}{
+		{"add", checkedAdd, math.MaxInt - 1, 1, math.MaxInt, true},
+		{"add", checkedAdd, math.MaxInt, 1, 0, false},
+		{"add", checkedAdd, math.MaxInt / 2, math.MaxInt/2 + 2, 0, false},
+		{"add", checkedAdd, math.MaxInt, 0, math.MaxInt, true},
+		{"mul", checkedMul, math.MaxInt / 2, 2, math.MaxInt - 1, true},
+		{"mul", checkedMul, math.MaxInt/2 + 1, 2, 0, false},
+		{"mul", checkedMul, math.MaxInt, 0, 0, true},
+		{"mul", checkedMul, 0, math.MaxInt, 0, true},
+		{"mul", checkedMul, 1 << 32, 1 << 31, 0, false},
+		{"concat", concat, math.MaxInt / 10, 7, math.MaxInt, true},
+		{"concat", concat, math.MaxInt / 10, 8, 0, false},
+		{"concat", concat, math.MaxInt/10 + 1, 0, 0, false},
+		{"concat", concat, 0, math.MaxInt, math.MaxInt, true},
+		{"concat", concat, 1, math.MaxInt, 0, false},
+		{"concat", concat, 9, 223372036854775807, math.MaxInt, true},
+		{"concat", concat, 10, 223372036854775807, 0, false},
+	}
+
+	for _, test := range tests {
+		result, ok := test.f(test.a, test.b)
+		if result != test.expected && ok || ok != test.ok {
+			t.Errorf("%s(%d, %d) = %d, %v; want %d, %v", test.name, test.a, test.b, result, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func concat(a, b int) (int, bool) {
+	n, err := concatNumbers(a, b)
+	return n, err == nil
+}
+
+func TestWriteSolutions(t *testing.T) {
+	equations, err := parse("3267: 81 40 27\n83: 17 5\n156: 15 6\n0: 2 0")
+	if err != nil {
+		t.Fatalf("parse() returned error: %v", err)
+	}
+	tests := []struct {
+		mode     string
+		expected string
+	}{
+		{"first", `line 1: 81 * 40 + 27 = 3267
+line 2: 83: 17 5 is unsolvable
+line 3: 15 || 6 = 156
+line 4: 2 * 0 = 0
+`},
+		{"all", `line 1: 81 * 40 + 27 = 3267
+line 1: 81 + 40 * 27 = 3267
+line 2: 83: 17 5 is unsolvable
+line 3: 15 || 6 = 156
+line 4: 2 * 0 = 0
+`},
+		{"count", `line 1: 3267: 81 40 27 has 2 solutions
+line 2: 83: 17 5 is unsolvable
+line 3: 156: 15 6 has 1 solution
+line 4: 0: 2 0 has 1 solution
+`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			var sb strings.Builder
+			if err := writeSolutions(&sb, equations, partTwoOperators, test.mode); err != nil {
+				t.Fatalf("writeSolutions() returned error: %v", err)
+			}
+			if sb.String() != test.expected {
+				t.Errorf("writeSolutions() =\n%s\nwant\n%s", sb.String(), test.expected)
+			}
+		})
+	}
+
+	if err := writeSolutions(io.Discard, equations, partTwoOperators, "none"); err == nil {
+		t.Error("writeSolutions() with an unknown mode succeeded")
+	}
+}
+
+// randomEquation returns n numbers from 1 to 9 and the result of combining
+// them with random part two operators.
+func randomEquation(r *rand.Rand, n int) (int, []int) {
+	numbers := make([]int, n)
+	for i := range numbers {
+		numbers[i] = 1 + r.Intn(9)
+	}
+	target := numbers[0]
+	for _, num := range numbers[1:] {
+		target, _ = partTwoOperators.operators[r.Intn(len(partTwoOperators.operators))].apply(target, num)
+	}
+	return target, numbers
+}
+
+func benchmarkEquations() []Equation {
+	r := rand.New(rand.NewSource(1))
+	var equations []Equation
+	for i := range 20 {
+		target, numbers := randomEquation(r, 12)
+		// Every other target is moved off by one, which is almost always
+		// unreachable, so failing searches are measured too.
+		equations = append(equations, Equation{Target: target + i%2, Operands: numbers, Line: i + 1})
+	}
+	return equations
+}
+
+func BenchmarkComputeWithPerms(b *testing.B) {
+	equations := benchmarkEquations()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range equations {
+			computeWithPerms(e.Target, e.Operands, partTwoOperators)
+		}
+	}
+}
+
+func benchmarkCompute(b *testing.B, workers int) {
+	r := rand.New(rand.NewSource(5))
+	var equations []Equation
+	for i := range 1000 {
+		target, numbers := randomEquation(r, 3+r.Intn(10))
+		equations = append(equations, Equation{Target: target + i%2, Operands: numbers, Line: i + 1})
+	}
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compute(ctx, equations, partOneOperators, partTwoOperators, workers)
+	}
+}
+
+func BenchmarkComputeSequential(b *testing.B) {
+	benchmarkCompute(b, 1)
+}
+
+func BenchmarkComputeParallel(b *testing.B) {
+	benchmarkCompute(b, runtime.GOMAXPROCS(0))
+}
+
+func BenchmarkSolvable(b *testing.B) {
+	equations := benchmarkEquations()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range equations {
+			solvable(e, partTwoOperators)
 		}
 	}
 }
 
 func TestParse(t *testing.T) {
 	tests := []struct {
+		name     string
 		input    string
-		expected map[int][]int
-		err      bool
+		expected []Equation
+		err      string
 	}{
 		{
+			name:  "two equations",
 			input: "10: 1 2 3 4\n22: 4 7 6",
-			expected: map[int][]int{
-				10: {1, 2, 3, 4},
-				22: {4, 7, 6},
+			expected: []Equation{
+				{Target: 10, Operands: []int{1, 2, 3, 4}, Line: 1},
+				{Target: 22, Operands: []int{4, 7, 6}, Line: 2},
+			},
+		},
+		{
+			name:  "duplicate targets keep input order",
+			input: "10: 1 9\n22: 4 7 6\n10: 2 5\n",
+			expected: []Equation{
+				{Target: 10, Operands: []int{1, 9}, Line: 1},
+				{Target: 22, Operands: []int{4, 7, 6}, Line: 2},
+				{Target: 10, Operands: []int{2, 5}, Line: 3},
 			},
-			err: false,
 		},
 		{
-			input: "1234: 12 34",
-			expected: map[int][]int{
-				1234: {12, 34},
+			name:  "windows line endings",
+			input: "1234: 12 34\r\n5:  5\r\n",
+			expected: []Equation{
+				{Target: 1234, Operands: []int{12, 34}, Line: 1},
+				{Target: 5, Operands: []int{5}, Line: 2},
 			},
-			err: false,
 		},
 		{
-			input:    "invalid",
-			expected: nil,
-			err:      true,
+			name:  "invalid",
+			input: "invalid",
+			err:   `line 1: invalid equation "invalid": expected a target and operands separated by :`,
 		},
 		{
-			input:    "10: 1 2 3 4\ninvalid\n",
-			expected: nil,
-			err:      true,
+			name:  "invalid second line",
+			input: "10: 1 2 3 4\ninvalid\n",
+			err:   `line 2: invalid equation "invalid": expected a target and operands separated by :`,
+		},
+		{
+			name:  "blank line",
+			input: "10: 1 2 3 4\n\n22: 4 7 6",
+			err:   `line 2: invalid equation "": expected a target and operands separated by :`,
+		},
+		{
+			name:  "invalid target",
+			input: "x: 1 2",
+			err:   `line 1: invalid equation "x: 1 2": invalid number "x"`,
+		},
+		{
+			name:  "negative operand",
+			input: "10: 11 -1",
+			err:   `line 1: invalid equation "10: 11 -1": invalid number "-1"`,
+		},
+		{
+			name:  "no operands",
+			input: "10:",
+			err:   `line 1: invalid equation "10:": no operands`,
+		},
+		{
+			name:  "target past the largest int",
+			input: "9223372036854775808: 9223372036854775807 1",
+			expected: []Equation{
+				{BigTarget: new(big.Int).Lsh(big.NewInt(1), 63), Operands: []int{math.MaxInt, 1}, Line: 1},
+			},
+		},
+		{
+			name:  "operand past the largest int",
+			input: "10: 9223372036854775808",
+			err:   `line 1: invalid equation "10: 9223372036854775808": number "9223372036854775808" is too large: value out of range`,
+		},
+		{
+			name:  "negative target past the largest int",
+			input: "-9223372036854775809: 1",
+			err:   `line 1: invalid equation "-9223372036854775809: 1": invalid number "-9223372036854775809"`,
 		},
 	}
 
 	for _, test := range tests {
-		result, err := parse(test.input)
-		if test.err {
-			if err == nil {
-				t.Errorf("parse(%q) expected error but got none", test.input)
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parse(test.input)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("parse(%q) error = %v; want %s", test.input, err, test.err)
+				}
+				return
 			}
-		} else {
 			if err != nil {
-				t.Errorf("parse(%q) returned error: %v", test.input, err)
+				t.Fatalf("parse(%q) returned error: %v", test.input, err)
 			}
-			if len(result) != len(test.expected) {
+			if !reflect.DeepEqual(result, test.expected) {
 				t.Errorf("parse(%q) = %v; want %v", test.input, result, test.expected)
-				continue
 			}
-			for k, v := range result {
-				if len(v) != len(test.expected[k]) {
-					t.Errorf("parse(%q) = %v; want %v", test.input, result, test.expected)
-					break
+		})
+	}
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		expectedPartOne string
+		expectedPartTwo string
+	}{
+		{"demo", demoInput, "3749", "11387"},
+		{"duplicate targets", "10: 1 9\n10: 2 5\n10: 3 3\n10: 1 0", "20", "30"},
+		{"single operand", "7: 7\n8: 7", "7", "7"},
+		{"sums past the largest int", "9223372036854775807: 9223372036854775807\n9223372036854775807: 922337203685477580 7", "9223372036854775807", "18446744073709551614"},
+		{"target past the largest int", "18446744073709551614: 9223372036854775807 2\n10: 1 0", "18446744073709551614", "18446744073709551624"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			equations, err := parse(test.input)
+			if err != nil {
+				t.Fatalf("parse() returned error: %v", err)
+			}
+			for _, workers := range []int{1, 2, 8} {
+				partOne, partTwo, err := compute(context.Background(), equations, partOneOperators, partTwoOperators, workers)
+				if err != nil {
+					t.Fatalf("compute(%d workers) returned error: %v", workers, err)
 				}
-				for i, num := range v {
-					if num != test.expected[k][i] {
-						t.Errorf("parse(%q) = %v; want %v", test.input, result, test.expected)
-						break
-					}
+				if partOne.String() != test.expectedPartOne || partTwo.String() != test.expectedPartTwo {
+					t.Errorf("compute(%d workers) = %d, %d; want %s, %s", workers, partOne, partTwo, test.expectedPartOne, test.expectedPartTwo)
 				}
 			}
+		})
+	}
+}
+
+func TestComputeMatchesSumSolvable(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	var equations []Equation
+	for i := range 500 {
+		target, numbers := randomEquation(r, 1+r.Intn(8))
+		equations = append(equations, Equation{Target: target + r.Intn(2), Operands: numbers, Line: i + 1})
+	}
+	ctx := context.Background()
+	for _, sets := range [][2]operatorSet{
+		{partOneOperators, partTwoOperators},
+		// Part one is not included in part two, so solving part one says
+		// nothing about part two.
+		{mustParseOperators("-,*"), partTwoOperators},
+		{partOneOperators, {operators: partTwoOperators.operators, precedence: true}},
+	} {
+		expectedPartOne, err := sumSolvable(ctx, equations, sets[0], 1)
+		if err != nil {
+			t.Fatalf("sumSolvable() returned error: %v", err)
+		}
+		expectedPartTwo, err := sumSolvable(ctx, equations, sets[1], 1)
+		if err != nil {
+			t.Fatalf("sumSolvable() returned error: %v", err)
+		}
+		for _, workers := range []int{1, 3, 16} {
+			partOne, partTwo, err := compute(ctx, equations, sets[0], sets[1], workers)
+			if err != nil {
+				t.Fatalf("compute() returned error: %v", err)
+			}
+			if partOne.Cmp(expectedPartOne) != 0 || partTwo.Cmp(expectedPartTwo) != 0 {
+				t.Errorf("compute(%v, %v, %d workers) = %d, %d; want %d, %d", sets[0], sets[1], workers, partOne, partTwo, expectedPartOne, expectedPartTwo)
+			}
+		}
+	}
+}
+
+func TestComputeCancelled(t *testing.T) {
+	equations, err := parse(demoInput)
+	if err != nil {
+		t.Fatalf("parse() returned error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := compute(ctx, equations, partOneOperators, partTwoOperators, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("compute() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := sumSolvable(ctx, equations, partTwoOperators, 2); !errors.Is(err, context.Canceled) {
+		t.Errorf("sumSolvable() error = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestOperatorSetIncludes(t *testing.T) {
+	tests := []struct {
+		set, other operatorSet
+		expected   bool
+	}{
+		{partTwoOperators, partOneOperators, true},
+		{partOneOperators, partTwoOperators, false},
+		{partOneOperators, partOneOperators, true},
+		{mustParseOperators("*,+"), partOneOperators, true},
+		{mustParseOperators("+,-,*,||"), mustParseOperators("-,||"), true},
+		{partTwoOperators, mustParseOperators("-"), false},
+		{operatorSet{operators: partTwoOperators.operators, precedence: true}, partOneOperators, false},
+		{operatorSet{operators: partTwoOperators.operators, precedence: true}, operatorSet{operators: partOneOperators.operators, precedence: true}, true},
+	}
+
+	for _, test := range tests {
+		if result := test.set.includes(test.other); result != test.expected {
+			t.Errorf("%v includes %v (precedence %v, %v) = %v; want %v", test.set, test.other, test.set.precedence, test.other.precedence, result, test.expected)
 		}
 	}
 }
+
+const demoInput = `190: 10 19
+3267: 81 40 27
+83: 17 5
+156: 15 6
+7290: 6 8 6 15
+161011: 16 10 13
+192: 17 8 14
+21037: 9 7 18 13
+292: 11 6 16 20`
@@ -1,5 +1,3 @@
 module github.com/MatthewLavine/advent-of-code-2024
 
 go 1.22.3
-
-require golang.org/x/exp v0.0.0-20241204233417-43b7b7cde48d